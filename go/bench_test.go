@@ -118,3 +118,137 @@ func BenchmarkParseBlocks_MultipleBlocks(b *testing.B) {
 		_, _ = parser.ParseBlocks(text)
 	}
 }
+
+// BenchmarkParseBlocks_JSONHeavy benchmarks ParseBlocks with blocks dominated
+// by large multi-line JSON values, whose lines are skipped by block-boundary
+// detection while the value is open.
+func BenchmarkParseBlocks_JSONHeavy(b *testing.B) {
+	labels := []Label{
+		{Name: "Task", IsBlockStart: true},
+		{Name: "Input", IsJSON: true},
+		{Name: "Status"},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		b.Fatalf("failed to create parser: %v", err)
+	}
+
+	var textBuilder strings.Builder
+	for i := 1; i <= 8; i++ {
+		textBuilder.WriteString("Task: Task ")
+		textBuilder.WriteString(strconv.Itoa(i))
+		textBuilder.WriteString("\nInput: {\n")
+		for j := 0; j < 100; j++ {
+			textBuilder.WriteString("  \"field")
+			textBuilder.WriteString(strconv.Itoa(j))
+			textBuilder.WriteString("\": {\"value\": ")
+			textBuilder.WriteString(strconv.Itoa(j))
+			textBuilder.WriteString(", \"note\": \"Task: not a boundary\"},\n")
+		}
+		textBuilder.WriteString("  \"last\": true\n}\nStatus: completed\n\n")
+	}
+
+	text := textBuilder.String()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = parser.ParseBlocks(text)
+	}
+}
+
+// BenchmarkParse_LongMultilineValue benchmarks Parse with a few labels whose
+// values span many continuation lines (~2000 lines, ~100 KB).
+func BenchmarkParse_LongMultilineValue(b *testing.B) {
+	labels := []Label{
+		{Name: "Thought"},
+		{Name: "Action"},
+		{Name: "Action Input"},
+		{Name: "Observation"},
+		{Name: "Final Answer"},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		b.Fatalf("failed to create parser: %v", err)
+	}
+
+	var textBuilder strings.Builder
+	textBuilder.WriteString("Thought: reviewing the file\n")
+	for i := 0; i < 2000; i++ {
+		textBuilder.WriteString("line ")
+		textBuilder.WriteString(strconv.Itoa(i))
+		textBuilder.WriteString(" of a long observation, with some - punctuation: here\n")
+	}
+	textBuilder.WriteString("Final Answer: done\n")
+
+	text := textBuilder.String()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = parser.Parse(text)
+	}
+}
+
+// BenchmarkParserCache_Hit benchmarks fetching a parser for a repeated config from the WASM parser cache.
+func BenchmarkParserCache_Hit(b *testing.B) {
+	labels := []Label{
+		{Name: "Thought"},
+		{Name: "Action"},
+		{Name: "Action Input", IsJSON: true},
+		{Name: "Observation"},
+		{Name: "Final Answer"},
+	}
+	cache := newParserCache(defaultParserCacheSize)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := cache.get(labels, nil); err != nil {
+			b.Fatalf("failed to get parser: %v", err)
+		}
+	}
+}
+
+// BenchmarkParserCache_Miss benchmarks building a parser on every request, as without the cache.
+func BenchmarkParserCache_Miss(b *testing.B) {
+	labels := []Label{
+		{Name: "Thought"},
+		{Name: "Action"},
+		{Name: "Action Input", IsJSON: true},
+		{Name: "Observation"},
+		{Name: "Final Answer"},
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewParser(labels, nil); err != nil {
+			b.Fatalf("failed to create parser: %v", err)
+		}
+	}
+}
+
+// BenchmarkParse_ManyLabels benchmarks Parse with a large schema (200 labels),
+// where label lines are matched through the first-word pattern index.
+func BenchmarkParse_ManyLabels(b *testing.B) {
+	labels := make([]Label, 200)
+	for i := range labels {
+		labels[i] = Label{Name: "Field" + strconv.Itoa(i) + " Value"}
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		b.Fatalf("failed to create parser: %v", err)
+	}
+
+	var sb strings.Builder
+	for i := 0; i < 200; i += 2 {
+		sb.WriteString("Field" + strconv.Itoa(i) + " Value: some text for this field\n")
+		sb.WriteString("  continued on a second line\n")
+	}
+	text := sb.String()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _ = parser.Parse(text)
+	}
+}
@@ -1,59 +1,670 @@
 package structuredparse
 
 import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
 // ParseBlocks parses the text into blocks, splitting at the block start label.
+// Content before the first block start is ignored unless ImplicitFirstBlock is set.
+// When block start labels declare BlockFields, each block is parsed with only
+// the labels of the block start that opened it. Lines matching
+// BlockSeparatorPattern also split blocks and are dropped.
 func (p *Parser) ParseBlocks(text string) ([]map[string]interface{}, []string) {
-	blockLabel := ""
-	for _, label := range p.labels {
-		if label.IsBlockStart {
-			blockLabel = label.Name
-			break
+	results, errs := p.parseBlocks(text)
+	return results, errorStrings(p.limitErrors(errs))
+}
+
+// BlockStyle describes how a block was written, as returned by
+// ParseBlocksWithStyle.
+type BlockStyle struct {
+	Separator string // Separator run used by most of the block's label lines ("" if none)
+	Indent    string // Leading whitespace used by most indented continuation lines ("" if none)
+}
+
+// ParseBlocksWithStyle parses the text like ParseBlocks and also reports each
+// block's dominant separator and indentation, so that a reformatter can
+// normalize inconsistent blocks to one style. Separators are taken from label
+// lines as ClassifyLine reports them; indentation from the non-label lines
+// that start with whitespace. Ties go to the style seen first.
+func (p *Parser) ParseBlocksWithStyle(text string) ([]map[string]interface{}, []BlockStyle, []string) {
+	if errList := p.checkBlockConfig(); errList != nil {
+		return nil, nil, errorStrings(errList)
+	}
+	raw, errList := p.splitBlocks(text)
+	var (
+		results []map[string]interface{}
+		styles  []BlockStyle
+	)
+	for _, block := range raw {
+		result, blockErr := p.parseBlock(block)
+		errList = append(errList, blockErr...)
+		results = append(results, result)
+		styles = append(styles, p.blockStyle(block.lines))
+	}
+	return results, styles, errorStrings(p.limitErrors(errList))
+}
+
+// blockStyle finds the dominant separator and indentation of a block's lines.
+func (p *Parser) blockStyle(lines []string) BlockStyle {
+	var separators, indents []string
+	for _, line := range lines {
+		if match, ok := p.ClassifyLine(line); ok {
+			separators = append(separators, match.Separator)
+		} else if trimmed := strings.TrimLeftFunc(line, unicode.IsSpace); trimmed != "" && len(trimmed) < len(line) {
+			indents = append(indents, line[:len(line)-len(trimmed)])
+		}
+	}
+	return BlockStyle{Separator: mostCommon(separators), Indent: mostCommon(indents)}
+}
+
+// mostCommon returns the most frequent string in values, preferring the one
+// seen first on ties, or "" if values is empty.
+func mostCommon(values []string) string {
+	counts := make(map[string]int, len(values))
+	best, bestCount := "", 0
+	for _, v := range values {
+		counts[v]++
+		if counts[v] > bestCount {
+			best, bestCount = v, counts[v]
 		}
 	}
-	if blockLabel == "" {
-		return nil, []string{"no block start label defined - must have at least one"}
+	return best
+}
+
+// parseBlocks implements ParseBlocks, returning structured errors.
+func (p *Parser) parseBlocks(text string) ([]map[string]interface{}, []*ParseError) {
+	if errList := p.checkBlockConfig(); errList != nil {
+		return nil, errList
+	}
+	raw, errList := p.splitBlocks(text)
+	var results []map[string]interface{}
+	for _, block := range raw {
+		result, blockErr := p.parseBlock(block)
+		errList = append(errList, blockErr...)
+		results = append(results, result)
+	}
+	return results, errList
+}
+
+// splitBlocks cleans the text and divides it into unparsed blocks, along with
+// any errors for truncated long lines. The block configuration must already
+// have been checked.
+func (p *Parser) splitBlocks(text string) ([]rawBlock, []*ParseError) {
+
+	lines := splitAndTrimLines(p.clean(text))
+	// Long lines are truncated (and reported) once here; blocks are then
+	// re-parsed from the already-truncated lines.
+	errList := p.guardLineLength(lines)
+	p.blankPreamble(lines)
+
+	var raw []rawBlock
+	splitter := p.newBlockSplitter()
+	for idx, line := range lines {
+		if block, ok := splitter.add(idx, line); ok {
+			raw = append(raw, block)
+		}
+	}
+	if block, ok := splitter.finish(); ok {
+		raw = append(raw, block)
 	}
+	return raw, errList
+}
 
-	cleaned := cleanText(text)
-	lines := splitAndTrimLines(cleaned)
+// ParseBlocksReader parses blocks like ParseBlocks while reading r line by
+// line, calling fn with each block's 0-based index, result and errors as soon
+// as the block is complete, so that large inputs are never held in memory at
+// once. Markdown code fences may span any number of lines and reads; input is
+// buffered only while one is open, or to the end under UnwrapOuterFenceOnly
+// when the input opens with a fence. Errors for truncated long lines are
+// reported with the block that contains them.
+//
+// Reading stops at the first error returned by fn or r, which is returned;
+// a missing block start label is returned as a *ParseError. The error is nil
+// once r is exhausted.
+func (p *Parser) ParseBlocksReader(r io.Reader, fn func(int, map[string]interface{}, []string) error) error {
+	if errList := p.checkBlockConfig(); errList != nil {
+		return errList[0]
+	}
 
 	var (
-		blocks       [][]string
-		currentBlock []string
-		inBlock      bool
+		reader   = bufio.NewReader(r)
+		splitter = p.newBlockSplitter()
+		index    int
+		idx      int
+		started  bool
+		// preamble is true until the first label line under
+		// SkipPreambleUntilFirstLabel.
+		preamble = p.opts.SkipPreambleUntilFirstLabel
+		lineErrs []*ParseError
+		// pending holds raw lines until every code fence and inline code span
+		// in them is closed, so they can be cleaned like a whole text.
+		pending []string
+		ticks   int
+		fences  int
+		// outerFence is set under UnwrapOuterFenceOnly when the first
+		// non-blank line opens a fence. Only the end of the input shows
+		// whether that fence encloses all of it, so input is then held until
+		// EOF; otherwise chunks are passed through with their fences intact.
+		outerFence bool
+		seenText   bool
 	)
+	emit := func(block rawBlock) error {
+		result, errs := p.parseBlock(block)
+		errs = append(lineErrs, errs...)
+		lineErrs = nil
+		err := fn(index, result, errorStrings(p.limitErrors(errs)))
+		index++
+		return err
+	}
+	flush := func() error {
+		text := normalizeNewlines(strings.Join(pending, "\n"))
+		if !p.opts.UnwrapOuterFenceOnly || outerFence {
+			text = p.stripMarkup(text)
+		}
+		cleaned := splitAndTrimLines(text)
+		pending = pending[:0]
+		for _, line := range cleaned {
+			if !started {
+				// Leading blank space is dropped, as cleanText does.
+				if line = strings.TrimLeftFunc(line, unicode.IsSpace); line == "" {
+					continue
+				}
+				started = true
+			}
+			var lineErr *ParseError
+			if line, lineErr = p.guardLine(idx, line); lineErr != nil {
+				lineErrs = append(lineErrs, lineErr)
+			}
+			if preamble {
+				if labelName, _ := p.parseLine(line); labelName == "" {
+					line = ""
+				} else {
+					preamble = false
+				}
+			}
+			block, ok := splitter.add(idx, line)
+			idx++
+			if ok {
+				if err := emit(block); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
 
-	for _, line := range lines {
-		labelName, _ := p.parseLine(line)
-		if strings.ToLower(labelName) == blockLabel {
-			if inBlock && len(currentBlock) > 0 {
-				blocks = append(blocks, currentBlock)
-				currentBlock = []string{}
+	for {
+		line, readErr := reader.ReadString('\n')
+		if readErr != nil && readErr != io.EOF {
+			return readErr
+		}
+		if line != "" || readErr == nil {
+			// Dropping "\r\n" whole keeps the CR from becoming a newline of its
+			// own when pending is normalized.
+			line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			pending = append(pending, line)
+			if !seenText && strings.TrimSpace(line) != "" {
+				seenText = true
+				outerFence = p.opts.UnwrapOuterFenceOnly && strings.HasPrefix(strings.TrimSpace(line), "```") && fenceKind(line) != noMarkdownFence
+			}
+			ticks += strings.Count(line, "`")
+			fences += strings.Count(line, "```")
+			if ticks%2 == 0 && fences%2 == 0 && !outerFence {
+				if err := flush(); err != nil {
+					return err
+				}
 			}
-			inBlock = true
 		}
-		if inBlock {
-			currentBlock = append(currentBlock, line)
+		if readErr == io.EOF {
+			break
+		}
+	}
+	if len(pending) > 0 {
+		if err := flush(); err != nil {
+			return err
 		}
 	}
-	if inBlock && len(currentBlock) > 0 {
-		blocks = append(blocks, currentBlock)
+	if block, ok := splitter.finish(); ok {
+		return emit(block)
+	}
+	return nil
+}
+
+// checkBlockConfig reports an error if the parser has no way to split blocks.
+func (p *Parser) checkBlockConfig() []*ParseError {
+	if p.blockSepRe != nil {
+		return nil
+	}
+	for _, label := range p.labels {
+		if label.IsBlockStart {
+			return nil
+		}
 	}
+	return []*ParseError{{
+		Code:    CodeNoBlockStart,
+		Message: "no block start label defined - must have at least one",
+	}}
+}
+
+// rawBlock is the unparsed content of a single block.
+type rawBlock struct {
+	lines     []string
+	start     string // Lowercase block start label that opened the block ("" if implicit or opened by a divider)
+	firstLine int    // 1-based input line number at which the block begins, for error messages
+}
+
+// blockSplitter divides cleaned input lines into blocks, one line at a time.
+type blockSplitter struct {
+	p             *Parser
+	hasBlockStart bool
+	current       rawBlock
+	inBlock       bool
+	// implicit is true while collecting content that precedes the first
+	// block start under ImplicitFirstBlock; such content only becomes a
+	// block if it contains at least one recognized label.
+	implicit         bool
+	implicitHasLabel bool
+	// inJSON tracks whether the current field is an IsJSON label whose
+	// value has unbalanced brackets; block boundaries are not detected
+	// until the value is closed.
+	inJSON  bool
+	balance jsonBalance
+	// inFence tracks an open \{{ ... }} escape fence, inside which lines
+	// never start a block.
+	inFence bool
+	// awaitValue mirrors parseLines: the line after a ValueOnNextLine
+	// label without a value never starts a block.
+	awaitValue bool
+	// blankBounded mirrors parseLines for StopAtBlankLine fields: until the
+	// first blank line, their lines never start a block.
+	blankBounded bool
+	// hasField is true once the current block has a label other than the
+	// block start, for SkipEmptyBlocks.
+	hasField bool
+	// started is true once any block has begun at a block start or divider.
+	// Until then, under FallbackSingleBlock, lines outside a block are kept
+	// in preStart in case none ever does.
+	started  bool
+	preStart []string
+}
 
+// newBlockSplitter returns a splitter positioned at the start of the input.
+func (p *Parser) newBlockSplitter() *blockSplitter {
+	hasBlockStart := false
+	for _, label := range p.labels {
+		if label.IsBlockStart {
+			hasBlockStart = true
+			break
+		}
+	}
+	return &blockSplitter{
+		p:             p,
+		hasBlockStart: hasBlockStart,
+		current:       rawBlock{firstLine: 1},
+		// Without a block start label, dividers alone delimit blocks, so the
+		// content before the first one is a block too.
+		inBlock:  p.opts.ImplicitFirstBlock || !hasBlockStart,
+		implicit: p.opts.ImplicitFirstBlock,
+	}
+}
+
+// add processes the line at 0-based index idx. When the line ends the
+// current block, the completed block is returned with true.
+func (s *blockSplitter) add(idx int, line string) (rawBlock, bool) {
+	p := s.p
+	if s.inFence {
+		_, s.inFence = stripFences(line, true)
+		s.appendLine(line)
+		return rawBlock{}, false
+	}
+	_, s.inFence = stripFences(line, false)
+	if s.blankBounded {
+		s.blankBounded = line != ""
+		s.appendLine(line)
+		return rawBlock{}, false
+	}
+	if s.inJSON && s.balance.open() {
+		s.balance.feed(line)
+		s.appendLine(line)
+		return rawBlock{}, false
+	}
+	if p.blockSepRe != nil && p.blockSepRe.MatchString(strings.TrimSpace(line)) {
+		s.inJSON = false
+		return s.startBlock("", idx+2)
+	}
+	labelName, value := "", ""
+	if s.awaitValue && line != "" {
+		s.awaitValue = false
+	} else {
+		labelName, value = p.parseLine(line)
+	}
+	if labelName != "" {
+		s.awaitValue = value == "" && p.labelMap[labelName].ValueOnNextLine
+		s.blankBounded = p.labelMap[labelName].StopAtBlankLine
+		s.inJSON = p.labelMap[labelName].IsJSON
+		s.balance = jsonBalance{}
+		if s.inJSON {
+			s.balance.feed(value)
+		}
+	} else if s.inJSON {
+		s.balance.feed(line)
+	}
 	var (
-		results []map[string]interface{}
-		errList []string
+		done rawBlock
+		ok   bool
 	)
-	for _, blockLines := range blocks {
-		blockText := strings.Join(blockLines, "\n")
-		result, blockErr := p.parseLines(blockText)
-		if len(blockErr) > 0 {
-			errList = append(errList, blockErr...)
+	if labelName != "" && p.labelMap[labelName].IsBlockStart {
+		done, ok = s.startBlock(labelName, idx+1)
+	} else if labelName != "" {
+		s.hasField = true
+		if s.implicit {
+			s.implicitHasLabel = true
 		}
-		results = append(results, result)
 	}
-	return results, errList
+	s.appendLine(line)
+	return done, ok
+}
+
+// finish returns the last block, if any, once the input is exhausted.
+func (s *blockSplitter) finish() (rawBlock, bool) {
+	if s.p.opts.FallbackSingleBlock && !s.started {
+		lines := s.preStart
+		if s.inBlock {
+			lines = s.current.lines
+		}
+		return rawBlock{lines: lines, firstLine: 1}, hasContent(lines)
+	}
+	return s.current, s.keepCurrent()
+}
+
+// startBlock begins a new block at the given line, returning the previous
+// one if it should be kept.
+func (s *blockSplitter) startBlock(start string, firstLine int) (rawBlock, bool) {
+	done, ok := s.current, s.keepCurrent()
+	s.current = rawBlock{lines: []string{}, start: start, firstLine: firstLine}
+	s.implicit = false
+	s.inBlock = true
+	s.hasField = false
+	s.started = true
+	s.preStart = nil
+	return done, ok
+}
+
+// keepCurrent reports whether the block being collected should be emitted.
+func (s *blockSplitter) keepCurrent() bool {
+	if s.p.opts.SkipEmptyBlocks && s.current.start != "" && !s.hasField {
+		return false
+	}
+	return s.inBlock && hasContent(s.current.lines) && (!s.implicit || s.implicitHasLabel)
+}
+
+// appendLine adds line to the current block, if one is being collected.
+func (s *blockSplitter) appendLine(line string) {
+	if s.inBlock {
+		s.current.lines = append(s.current.lines, line)
+	} else if s.p.opts.FallbackSingleBlock && !s.started {
+		s.preStart = append(s.preStart, line)
+	}
+}
+
+// parseBlock parses a single block's lines.
+func (p *Parser) parseBlock(block rawBlock) (map[string]interface{}, []*ParseError) {
+	blockLines := block.lines
+	var intro string
+	if p.opts.BlockIntroKey != "" {
+		blockLines, intro = p.splitBlockIntro(blockLines, block.start)
+	}
+	blockParser := p
+	if scoped := p.blockParsers[block.start]; scoped != nil {
+		blockParser = scoped
+	}
+	result, errList := blockParser.parseLinesAt(strings.Join(blockLines, "\n"), block.firstLine, nil)
+	if p.opts.BlockIntroKey != "" {
+		result[p.opts.BlockIntroKey] = intro
+	}
+	return result, errList
+}
+
+// hasContent reports whether a block has at least one non-blank line, so that
+// consecutive dividers do not produce empty blocks.
+func hasContent(blockLines []string) bool {
+	for _, line := range blockLines {
+		if strings.TrimSpace(line) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// splitBlockIntro separates the non-label lines immediately following a
+// block's start line from the rest of the block. It returns the block lines
+// with the intro blanked out (keeping line numbers stable) and the trimmed
+// intro text.
+func (p *Parser) splitBlockIntro(blockLines []string, blockLabel string) ([]string, string) {
+	if len(blockLines) == 0 || blockLabel == "" {
+		return blockLines, ""
+	}
+	if labelName, _ := p.parseLine(blockLines[0]); labelName != blockLabel {
+		return blockLines, ""
+	}
+	end := 1
+	for end < len(blockLines) {
+		if labelName, _ := p.parseLine(blockLines[end]); labelName != "" {
+			break
+		}
+		end++
+	}
+	intro := strings.TrimSpace(strings.Join(blockLines[1:end], "\n"))
+	remaining := make([]string, len(blockLines))
+	copy(remaining, blockLines)
+	for i := 1; i < end; i++ {
+		remaining[i] = ""
+	}
+	return remaining, intro
+}
+
+// ParseBlocksByKey parses the text into blocks like ParseBlocks and indexes them
+// by the string value of keyField (matched case-insensitively against the
+// declared labels). Blocks whose key is missing, empty, or not a single string
+// are skipped with an error. On duplicate keys an error is recorded and the
+// first block is kept, or the last one when DuplicatePolicy is DuplicateKeepLast.
+// With BlockStartAsKey set, an empty keyField keys each block by its block
+// start value, which is removed from the block.
+func (p *Parser) ParseBlocksByKey(text, keyField string) (map[string]map[string]interface{}, []string) {
+	blocks, errList := p.parseBlocks(text)
+	if blocks == nil && len(errList) > 0 {
+		return nil, errorStrings(p.limitErrors(errList))
+	}
+
+	keyName := p.originalNames[strings.ToLower(keyField)]
+	if keyName == "" {
+		keyName = keyField
+	}
+
+	byStart := keyField == "" && p.opts.BlockStartAsKey
+	indexed := make(map[string]map[string]interface{}, len(blocks))
+	for i, block := range blocks {
+		if byStart {
+			keyName = p.blockStartName(block)
+		}
+		key, ok := block[keyName].(string)
+		if !ok || key == "" {
+			message := "block " + strconv.Itoa(i+1) + " has no '" + keyName + "' value"
+			if keyName == "" {
+				message = "block " + strconv.Itoa(i+1) + " has no block start value"
+			}
+			errList = append(errList, &ParseError{
+				Code:    CodeMissingBlockKey,
+				Label:   keyName,
+				Message: message,
+			})
+			continue
+		}
+		if byStart {
+			delete(block, keyName)
+		}
+		if _, exists := indexed[key]; exists {
+			errList = append(errList, &ParseError{
+				Code:    CodeDuplicateBlockKey,
+				Label:   keyName,
+				Message: "duplicate '" + keyName + "' value '" + key + "' in block " + strconv.Itoa(i+1),
+			})
+			if p.opts.DuplicatePolicy != DuplicateKeepLast {
+				continue
+			}
+		}
+		indexed[key] = block
+	}
+	return indexed, errorStrings(p.limitErrors(errList))
+}
+
+// ResolveBlockRefs links blocks that reference one another, as in DAG-style
+// task output ("Depends On: 1, 3"). Each string in a block's refField, split
+// on commas, names the block whose idField has that value (compared
+// case-insensitively). With an empty idField, references are 1-based block
+// numbers, optionally written as "block 2". Field names are the result keys,
+// i.e. the labels' original casing.
+//
+// The returned blocks are shallow copies of the input, each with the 0-based
+// indexes of the blocks it references stored as a []int under refField +
+// "Resolved". References to no block, and ids used by more than one block
+// (the first is kept), are reported as errors. Cycles are not checked.
+func ResolveBlockRefs(blocks []map[string]interface{}, idField, refField string) ([]map[string]interface{}, []string) {
+	var errList []string
+	ids := make(map[string]int, len(blocks))
+	if idField != "" {
+		for i, block := range blocks {
+			id, _ := block[idField].(string)
+			id = strings.ToLower(strings.TrimSpace(id))
+			if id == "" {
+				continue
+			}
+			if _, exists := ids[id]; exists {
+				errList = append(errList, "duplicate '"+idField+"' value '"+strings.TrimSpace(block[idField].(string))+"' in block "+strconv.Itoa(i+1))
+				continue
+			}
+			ids[id] = i
+		}
+	}
+
+	resolved := make([]map[string]interface{}, len(blocks))
+	for i, block := range blocks {
+		annotated := make(map[string]interface{}, len(block)+1)
+		for k, v := range block {
+			annotated[k] = v
+		}
+		links := []int{}
+		for _, value := range AsStringSlice(block[refField]) {
+			for _, ref := range strings.Split(value, ",") {
+				if ref = strings.TrimSpace(ref); ref == "" {
+					continue
+				}
+				target, ok := blockRefIndex(ref, idField, ids, len(blocks))
+				if !ok {
+					errList = append(errList, "block "+strconv.Itoa(i+1)+": '"+refField+"' references unknown block '"+ref+"'")
+					continue
+				}
+				links = append(links, target)
+			}
+		}
+		annotated[refField+"Resolved"] = links
+		resolved[i] = annotated
+	}
+	return resolved, errList
+}
+
+// blockRefIndex returns the index of the block a single reference names.
+func blockRefIndex(ref, idField string, ids map[string]int, count int) (int, bool) {
+	if idField != "" {
+		index, ok := ids[strings.ToLower(ref)]
+		return index, ok
+	}
+	lower := strings.ToLower(ref)
+	if rest, found := strings.CutPrefix(lower, "block"); found {
+		lower = strings.TrimSpace(rest)
+	}
+	n, err := strconv.Atoi(lower)
+	if err != nil || n < 1 || n > count {
+		return 0, false
+	}
+	return n - 1, true
+}
+
+// blockStartName returns the original name of the block start label that
+// opened block, or "" if none did (as for a block opened by a divider).
+func (p *Parser) blockStartName(block map[string]interface{}) string {
+	for _, label := range p.labels {
+		if !label.IsBlockStart {
+			continue
+		}
+		name := p.originalNames[label.Name]
+		if value, ok := block[name]; ok && value != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// ParseBlocksToJSONL parses the text into blocks like ParseBlocks and writes each
+// block to w as a compact JSON object on its own line (newline-delimited JSON).
+// Parse errors are returned as with ParseBlocks; a failure to encode or write a
+// block is appended to the error list and stops further output.
+func (p *Parser) ParseBlocksToJSONL(text string, w io.Writer) []string {
+	blocks, errList := p.ParseBlocks(text)
+	enc := json.NewEncoder(w)
+	for _, block := range blocks {
+		if err := enc.Encode(block); err != nil {
+			errList = append(errList, "failed to write block: "+err.Error())
+			break
+		}
+	}
+	return errList
+}
+
+// jsonBalance incrementally tracks bracket nesting in JSON text, ignoring
+// brackets inside string literals.
+type jsonBalance struct {
+	depth    int
+	maxDepth int // Deepest nesting seen so far
+	inString bool
+	escaped  bool
+}
+
+// feed advances the tracker over the given text.
+func (b *jsonBalance) feed(text string) {
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		if b.inString {
+			switch {
+			case b.escaped:
+				b.escaped = false
+			case c == '\\':
+				b.escaped = true
+			case c == '"':
+				b.inString = false
+			}
+			continue
+		}
+		switch c {
+		case '"':
+			b.inString = true
+		case '{', '[':
+			b.depth++
+			b.maxDepth = max(b.maxDepth, b.depth)
+		case '}', ']':
+			if b.depth > 0 {
+				b.depth--
+			}
+		}
+	}
+}
+
+// open reports whether an object or array is still unclosed.
+func (b *jsonBalance) open() bool {
+	return b.depth > 0
 }
@@ -0,0 +1,218 @@
+package structuredparse
+
+import (
+	"strings"
+	"unicode"
+)
+
+// LineMatch describes how a single line matched a declared label.
+type LineMatch struct {
+	Canonical string // Declared label name, in its original casing
+	AsWritten string // Label text exactly as it appears in the line
+	Value     string // Trimmed value following the separator
+	Separator string // Separator run as written (e.g. ":" or "::")
+}
+
+// ClassifyLine reports whether a single line starts with a declared label and,
+// if so, how it was written. It applies the same exact and fuzzy matching as
+// Parse but is independent of it, for editor tooling and reformatting.
+func (p *Parser) ClassifyLine(line string) (LineMatch, bool) {
+	line = p.stripLabelPrefix(line)
+	if pat, loc := p.matchPattern(line); loc != nil {
+		sep := separatorGroup(line, loc)
+		return LineMatch{
+			Canonical: p.originalNames[pat.Name],
+			AsWritten: line[loc[2]:loc[3]],
+			Value:     trimRepeatedSeparator(sep, strings.TrimSpace(line[loc[1]:])),
+			Separator: sep,
+		}, true
+	}
+	if p.opts.FuzzyLabelDistance > 0 {
+		if labelName, value, ok := p.fuzzyMatch(line); ok {
+			loc := p.candidateRe.FindStringSubmatchIndex(line)
+			return LineMatch{
+				Canonical: p.originalNames[labelName],
+				AsWritten: line[loc[2]:loc[3]],
+				Value:     value,
+				Separator: separatorGroup(line, loc),
+			}, true
+		}
+	}
+	return LineMatch{}, false
+}
+
+// SeparatorStats counts, across texts, how often each separator run (as
+// written, e.g. ":" or "=>") introduced a field value. Every line that
+// ClassifyLine recognizes counts once, which makes the result useful for
+// seeing which separators a model favors and tuning prompt instructions.
+func SeparatorStats(p *Parser, texts []string) map[string]int {
+	stats := make(map[string]int)
+	for _, text := range texts {
+		for _, line := range splitAndTrimLines(p.clean(text)) {
+			if match, ok := p.ClassifyLine(line); ok {
+				stats[match.Separator]++
+			}
+		}
+	}
+	return stats
+}
+
+// ParseStats summarizes the lines of a text, as returned by Stats.
+type ParseStats struct {
+	Lines         int // Lines after markdown code fences are stripped
+	MatchedLabels int // Lines starting with a declared label
+	UnknownLabels int // Lines shaped like "Name: value" whose name is not declared
+	JSONFields    int // Matched lines whose label is IsJSON
+}
+
+// Stats classifies each line of text with ClassifyLine in a single pass,
+// without building a result, for cheap analytics over large corpora. Lines
+// are judged on their own, so label-looking lines inside multi-line values
+// are counted too, and only unindented lines count as unknown labels, as in
+// DiscoverLabels.
+func (p *Parser) Stats(text string) ParseStats {
+	var stats ParseStats
+	cleaned := p.clean(text)
+	if cleaned == "" {
+		return stats
+	}
+	for _, line := range splitAndTrimLines(cleaned) {
+		stats.Lines++
+		if match, ok := p.ClassifyLine(line); ok {
+			stats.MatchedLabels++
+			if p.labelMap[strings.ToLower(match.Canonical)].IsJSON {
+				stats.JSONFields++
+			}
+		} else if discoverLineRe.MatchString(line) {
+			stats.UnknownLabels++
+		}
+	}
+	return stats
+}
+
+// Token kinds reported by Tokenize.
+const (
+	TokenLabel     = "label-name"
+	TokenSeparator = "separator"
+	TokenValue     = "value"
+	TokenCodeFence = "code-fence"
+)
+
+// Token is a span of the raw input, as byte offsets [Start, End).
+type Token struct {
+	Kind  string
+	Start int
+	End   int
+}
+
+// Tokenize splits raw text into label, separator, value and code-fence spans
+// for syntax highlighting. It works directly on the input, so offsets need no
+// mapping back through line-ending or fence normalization. Label lines are
+// recognized as in ClassifyLine; any other non-blank line after the first
+// label is a continuation of its value. Lines inside a markdown fence opened
+// after the first label, or inside a \{{ ... }} escape fence, are values too,
+// while a fence enclosing the labels is only marked as code-fence. Fences are
+// judged as in UnwrapOuterFenceOnly. Whitespace is never part of a token.
+func (p *Parser) Tokenize(text string) []Token {
+	var (
+		tokens    []Token
+		seenLabel bool
+		// outerCode is true inside a markdown fence opened before the first
+		// label, and inCode inside one opened after it.
+		outerCode, inCode bool
+		inEscape          bool
+	)
+	for start := 0; start <= len(text); {
+		end := strings.IndexAny(text[start:], "\r\n")
+		if end < 0 {
+			end = len(text)
+		} else {
+			end += start
+		}
+		line := text[start:end]
+
+		trimmed := strings.TrimSpace(line)
+		lead := len(line) - len(strings.TrimLeftFunc(line, unicode.IsSpace))
+		stripped := p.stripLabelPrefix(line)
+		offset := start + len(line) - len(stripped)
+		if inCode || inEscape {
+			tokens = appendValueTokens(tokens, text, start, end)
+		} else if strings.HasPrefix(trimmed, "```") {
+			tokens = append(tokens, Token{TokenCodeFence, start + lead, start + lead + len(trimmed)})
+		} else if loc := p.tokenLoc(stripped); loc != nil {
+			seenLabel = true
+			tokens = append(tokens, Token{TokenLabel, offset + loc[2], offset + loc[3]})
+			if sepStart, sepEnd := separatorSpan(loc); sepStart >= 0 {
+				tokens = append(tokens, Token{TokenSeparator, offset + sepStart, offset + sepEnd})
+			}
+			tokens = appendValueTokens(tokens, text, offset+loc[1], end)
+		} else if seenLabel && trimmed != "" {
+			tokens = appendValueTokens(tokens, text, start, end)
+		}
+
+		if !inCode {
+			_, inEscape = stripFences(line, inEscape)
+		}
+		if kind := fenceKind(line); kind != noMarkdownFence && !inEscape {
+			switch {
+			case inCode:
+				inCode = false
+			case !seenLabel:
+				outerCode = !outerCode
+			case kind == bareMarkdownFence && outerCode:
+				outerCode = false
+			default:
+				inCode = true
+			}
+		}
+
+		if end == len(text) {
+			break
+		}
+		start = end + 1
+		if text[end] == '\r' && start < len(text) && text[start] == '\n' {
+			start++
+		}
+	}
+	return tokens
+}
+
+// tokenLoc matches a label line exactly or, failing that, fuzzily, returning
+// submatch indexes laid out as in matchPattern.
+func (p *Parser) tokenLoc(line string) []int {
+	if _, loc := p.matchPattern(line); loc != nil {
+		return loc
+	}
+	if p.opts.FuzzyLabelDistance > 0 {
+		if _, _, ok := p.fuzzyMatch(line); ok {
+			return p.candidateRe.FindStringSubmatchIndex(line)
+		}
+	}
+	return nil
+}
+
+// appendValueTokens appends tokens for the value text[start:end]. A markdown
+// fence ending it, as judged by fenceKind, gets a code-fence token of its own.
+func appendValueTokens(tokens []Token, text string, start, end int) []Token {
+	segment := text[start:end]
+	if fenceKind(segment) == noMarkdownFence {
+		return appendValueToken(tokens, text, start, end)
+	}
+	fenceStart := start + strings.LastIndex(segment, "```")
+	tokens = appendValueToken(tokens, text, start, fenceStart)
+	fenceEnd := fenceStart + len(strings.TrimRightFunc(text[fenceStart:end], unicode.IsSpace))
+	return append(tokens, Token{TokenCodeFence, fenceStart, fenceEnd})
+}
+
+// appendValueToken appends a value token for text[start:end] with
+// surrounding whitespace excluded, if anything remains.
+func appendValueToken(tokens []Token, text string, start, end int) []Token {
+	segment := text[start:end]
+	trimmedLeft := strings.TrimLeftFunc(segment, unicode.IsSpace)
+	valueStart := start + len(segment) - len(trimmedLeft)
+	valueEnd := valueStart + len(strings.TrimRightFunc(trimmedLeft, unicode.IsSpace))
+	if valueEnd > valueStart {
+		tokens = append(tokens, Token{TokenValue, valueStart, valueEnd})
+	}
+	return tokens
+}
@@ -11,32 +11,85 @@ import (
 
 // WasmResponse represents the standard response structure for all WASM functions.
 type WasmResponse struct {
-	Ok     bool                   `json:"ok"`
-	Result interface{}            `json:"result,omitempty"`
-	Errors []string               `json:"errors,omitempty"`
-	Error  string                 `json:"error,omitempty"`
+	Ok     bool        `json:"ok"`
+	Result interface{} `json:"result,omitempty"`
+	Errors []string    `json:"errors,omitempty"`
+	Error  string      `json:"error,omitempty"`
 }
 
 // Request represents a unified request structure.
 type Request struct {
-	Command string                 `json:"command"` // "parse", "parseBlocks", or "version"
-	Labels  []LabelJSON            `json:"labels,omitempty"`
-	Options *ParserOptionsJSON     `json:"options,omitempty"`
-	Text    string                 `json:"text,omitempty"`
+	Command string             `json:"command"` // "parse", "parseBlocks", or "version"
+	Labels  []LabelJSON        `json:"labels,omitempty"`
+	Options *ParserOptionsJSON `json:"options,omitempty"`
+	Text    string             `json:"text,omitempty"`
+	Pretty  bool               `json:"pretty,omitempty"` // Indent the response JSON for display
 }
 
 // LabelJSON represents a label in JSON format.
 type LabelJSON struct {
-	Name         string   `json:"name"`
-	Required     bool     `json:"required,omitempty"`
-	RequiredWith []string `json:"requiredWith,omitempty"`
-	IsJSON       bool     `json:"isJson,omitempty"`
-	IsBlockStart bool     `json:"isBlockStart,omitempty"`
+	Name                string            `json:"name"`
+	Required            bool              `json:"required,omitempty"`
+	RequiredWith        []string          `json:"requiredWith,omitempty"`
+	IsJSON              bool              `json:"isJson,omitempty"`
+	IsBlockStart        bool              `json:"isBlockStart,omitempty"`
+	Repeatable          bool              `json:"repeatable,omitempty"`
+	NestedLabels        []LabelJSON       `json:"nestedLabels,omitempty"`
+	StopAtBlankLine     bool              `json:"stopAtBlankLine,omitempty"`
+	Dedent              bool              `json:"dedent,omitempty"`
+	UnlessPresent       []string          `json:"unlessPresent,omitempty"`
+	BlockFields         []string          `json:"blockFields,omitempty"`
+	IncludeLabelInValue bool              `json:"includeLabelInValue,omitempty"`
+	ParseUnit           bool              `json:"parseUnit,omitempty"`
+	DedupeValues        bool              `json:"dedupeValues,omitempty"`
+	NormalizeCase       string            `json:"normalizeCase,omitempty"`
+	Enum                []string          `json:"enum,omitempty"`
+	ValueOnNextLine     bool              `json:"valueOnNextLine,omitempty"`
+	FlagOnly            bool              `json:"flagOnly,omitempty"`
+	Computed            string            `json:"computed,omitempty"`
+	ParagraphsAsArray   bool              `json:"paragraphsAsArray,omitempty"`
+	Recommended         bool              `json:"recommended,omitempty"`
+	MinCount            int               `json:"minCount,omitempty"`
+	MaxCount            int               `json:"maxCount,omitempty"`
+	SplitOn             string            `json:"splitOn,omitempty"`
+	Opaque              bool              `json:"opaque,omitempty"`
+	AllowEmpty          bool              `json:"allowEmpty,omitempty"`
+	RequireExactCase    bool              `json:"requireExactCase,omitempty"`
+	Meta                map[string]string `json:"meta,omitempty"`
+	NumberedSuffix      bool              `json:"numberedSuffix,omitempty"`
+	TrimTrailing        string            `json:"trimTrailing,omitempty"`
+	PreserveIndent      bool              `json:"preserveIndent,omitempty"`
 }
 
 // ParserOptionsJSON represents parser options in JSON format.
 type ParserOptionsJSON struct {
-	Separators string `json:"separators,omitempty"`
+	Separators                  string            `json:"separators,omitempty"`
+	RequireSpaceAfterSeparator  bool              `json:"requireSpaceAfterSeparator,omitempty"`
+	ImplicitFirstBlock          bool              `json:"implicitFirstBlock,omitempty"`
+	FuzzyLabelDistance          int               `json:"fuzzyLabelDistance,omitempty"`
+	BlockIntroKey               string            `json:"blockIntroKey,omitempty"`
+	MaxLineLength               int               `json:"maxLineLength,omitempty"`
+	ErrorOnLongLine             bool              `json:"errorOnLongLine,omitempty"`
+	DuplicatePolicy             string            `json:"duplicatePolicy,omitempty"` // "collect" (default), "first", or "last"
+	NormalizeUnicodePunctuation bool              `json:"normalizeUnicodePunctuation,omitempty"`
+	EmptyJSONAsNull             bool              `json:"emptyJsonAsNull,omitempty"`
+	WarnAmbiguousMatches        bool              `json:"warnAmbiguousMatches,omitempty"`
+	TreatTabAsSeparator         bool              `json:"treatTabAsSeparator,omitempty"`
+	BlockSeparatorPattern       string            `json:"blockSeparatorPattern,omitempty"`
+	DisallowInterleavedFields   bool              `json:"disallowInterleavedFields,omitempty"`
+	MaxErrors                   int               `json:"maxErrors,omitempty"`
+	StripLabelPrefixRunes       string            `json:"stripLabelPrefixRunes,omitempty"`
+	GlobalAliases               map[string]string `json:"globalAliases,omitempty"`
+	BlockStartAsKey             bool              `json:"blockStartAsKey,omitempty"`
+	AgentMode                   bool              `json:"agentMode,omitempty"`
+	FlexibleWordSeparators      bool              `json:"flexibleWordSeparators,omitempty"`
+	SplitInlineLabels           bool              `json:"splitInlineLabels,omitempty"`
+	EnforceOrder                bool              `json:"enforceOrder,omitempty"`
+	SkipPreambleUntilFirstLabel bool              `json:"skipPreambleUntilFirstLabel,omitempty"`
+	MaxJSONDepth                int               `json:"maxJsonDepth,omitempty"`
+	SkipEmptyBlocks             bool              `json:"skipEmptyBlocks,omitempty"`
+	FallbackSingleBlock         bool              `json:"fallbackSingleBlock,omitempty"`
+	UnwrapOuterFenceOnly        bool              `json:"unwrapOuterFenceOnly,omitempty"`
 }
 
 func main() {
@@ -83,7 +136,7 @@ func handleParse(req Request) {
 		Errors: errors,
 	}
 
-	writeResponse(response)
+	writeResponse(response, req.Pretty)
 }
 
 func handleParseBlocks(req Request) {
@@ -104,7 +157,7 @@ func handleParseBlocks(req Request) {
 		Errors: errors,
 	}
 
-	writeResponse(response)
+	writeResponse(response, req.Pretty)
 }
 
 func handleVersion() {
@@ -112,18 +165,44 @@ func handleVersion() {
 		Ok:     true,
 		Result: "1.0.0",
 	}
-	writeResponse(response)
+	writeResponse(response, false)
 }
 
 func convertLabelsFromJSON(jsonLabels []LabelJSON) []sp.Label {
 	labels := make([]sp.Label, len(jsonLabels))
 	for i, jl := range jsonLabels {
 		labels[i] = sp.Label{
-			Name:         jl.Name,
-			Required:     jl.Required,
-			RequiredWith: jl.RequiredWith,
-			IsJSON:       jl.IsJSON,
-			IsBlockStart: jl.IsBlockStart,
+			Name:                jl.Name,
+			Required:            jl.Required,
+			RequiredWith:        jl.RequiredWith,
+			IsJSON:              jl.IsJSON,
+			IsBlockStart:        jl.IsBlockStart,
+			Repeatable:          jl.Repeatable,
+			NestedLabels:        convertLabelsFromJSON(jl.NestedLabels),
+			StopAtBlankLine:     jl.StopAtBlankLine,
+			Dedent:              jl.Dedent,
+			UnlessPresent:       jl.UnlessPresent,
+			BlockFields:         jl.BlockFields,
+			IncludeLabelInValue: jl.IncludeLabelInValue,
+			ParseUnit:           jl.ParseUnit,
+			DedupeValues:        jl.DedupeValues,
+			NormalizeCase:       jl.NormalizeCase,
+			Enum:                jl.Enum,
+			ValueOnNextLine:     jl.ValueOnNextLine,
+			FlagOnly:            jl.FlagOnly,
+			Computed:            jl.Computed,
+			ParagraphsAsArray:   jl.ParagraphsAsArray,
+			Recommended:         jl.Recommended,
+			MinCount:            jl.MinCount,
+			MaxCount:            jl.MaxCount,
+			SplitOn:             jl.SplitOn,
+			Opaque:              jl.Opaque,
+			AllowEmpty:          jl.AllowEmpty,
+			RequireExactCase:    jl.RequireExactCase,
+			Meta:                jl.Meta,
+			NumberedSuffix:      jl.NumberedSuffix,
+			TrimTrailing:        jl.TrimTrailing,
+			PreserveIndent:      jl.PreserveIndent,
 		}
 	}
 	return labels
@@ -134,12 +213,59 @@ func convertOptionsFromJSON(jsonOpts *ParserOptionsJSON) *sp.ParserOptions {
 		return nil
 	}
 	return &sp.ParserOptions{
-		Separators: jsonOpts.Separators,
+		Separators:                  jsonOpts.Separators,
+		RequireSpaceAfterSeparator:  jsonOpts.RequireSpaceAfterSeparator,
+		ImplicitFirstBlock:          jsonOpts.ImplicitFirstBlock,
+		FuzzyLabelDistance:          jsonOpts.FuzzyLabelDistance,
+		BlockIntroKey:               jsonOpts.BlockIntroKey,
+		MaxLineLength:               jsonOpts.MaxLineLength,
+		ErrorOnLongLine:             jsonOpts.ErrorOnLongLine,
+		DuplicatePolicy:             convertDuplicatePolicyFromJSON(jsonOpts.DuplicatePolicy),
+		NormalizeUnicodePunctuation: jsonOpts.NormalizeUnicodePunctuation,
+		EmptyJSONAsNull:             jsonOpts.EmptyJSONAsNull,
+		WarnAmbiguousMatches:        jsonOpts.WarnAmbiguousMatches,
+		TreatTabAsSeparator:         jsonOpts.TreatTabAsSeparator,
+		BlockSeparatorPattern:       jsonOpts.BlockSeparatorPattern,
+		DisallowInterleavedFields:   jsonOpts.DisallowInterleavedFields,
+		MaxErrors:                   jsonOpts.MaxErrors,
+		StripLabelPrefixRunes:       jsonOpts.StripLabelPrefixRunes,
+		GlobalAliases:               jsonOpts.GlobalAliases,
+		BlockStartAsKey:             jsonOpts.BlockStartAsKey,
+		AgentMode:                   jsonOpts.AgentMode,
+		FlexibleWordSeparators:      jsonOpts.FlexibleWordSeparators,
+		SplitInlineLabels:           jsonOpts.SplitInlineLabels,
+		EnforceOrder:                jsonOpts.EnforceOrder,
+		SkipPreambleUntilFirstLabel: jsonOpts.SkipPreambleUntilFirstLabel,
+		MaxJSONDepth:                jsonOpts.MaxJSONDepth,
+		SkipEmptyBlocks:             jsonOpts.SkipEmptyBlocks,
+		FallbackSingleBlock:         jsonOpts.FallbackSingleBlock,
+		UnwrapOuterFenceOnly:        jsonOpts.UnwrapOuterFenceOnly,
 	}
 }
 
-func writeResponse(response WasmResponse) {
-	responseJSON, err := json.Marshal(response)
+// convertDuplicatePolicyFromJSON maps a JSON duplicate policy name to a DuplicatePolicy.
+// Unknown names fall back to the default collect behavior.
+func convertDuplicatePolicyFromJSON(policy string) sp.DuplicatePolicy {
+	switch policy {
+	case "first":
+		return sp.DuplicateKeepFirst
+	case "last":
+		return sp.DuplicateKeepLast
+	default:
+		return sp.DuplicateCollect
+	}
+}
+
+func writeResponse(response WasmResponse, pretty bool) {
+	var (
+		responseJSON []byte
+		err          error
+	)
+	if pretty {
+		responseJSON, err = json.MarshalIndent(response, "", "  ")
+	} else {
+		responseJSON, err = json.Marshal(response)
+	}
 	if err != nil {
 		writeError("failed to marshal response: " + err.Error())
 		return
@@ -155,4 +281,3 @@ func writeError(errMsg string) {
 	responseJSON, _ := json.Marshal(response)
 	fmt.Println(string(responseJSON))
 }
-
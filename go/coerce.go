@@ -0,0 +1,113 @@
+package structuredparse
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Coerce returns a copy of result with each field named in shape converted to
+// the given type: "int", "float", "bool", "json", or "string". Repeated values
+// are converted element by element. Fields that are absent or empty are left
+// as-is, as are fields not mentioned in shape. A field that fails to convert
+// keeps its original value and an error is recorded.
+func Coerce(result map[string]interface{}, shape map[string]string) (map[string]interface{}, []string) {
+	coerced := make(map[string]interface{}, len(result))
+	for k, v := range result {
+		coerced[k] = v
+	}
+
+	fields := make([]string, 0, len(shape))
+	for field := range shape {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields) // Deterministic error order
+
+	var errList []string
+	for _, field := range fields {
+		typ := shape[field]
+		value, ok := result[field]
+		if !ok || value == "" {
+			continue
+		}
+		var (
+			converted interface{}
+			err       error
+		)
+		if items, isSlice := value.([]interface{}); isSlice {
+			convertedItems := make([]interface{}, len(items))
+			for i, item := range items {
+				if convertedItems[i], err = coerceValue(item, typ); err != nil {
+					break
+				}
+			}
+			converted = convertedItems
+		} else {
+			converted, err = coerceValue(value, typ)
+		}
+		if err != nil {
+			errList = append(errList, "'"+field+"' cannot be converted to "+typ+": "+err.Error())
+			continue
+		}
+		coerced[field] = converted
+	}
+	return coerced, errList
+}
+
+// coerceValue converts a single value to the named type.
+func coerceValue(value interface{}, typ string) (interface{}, error) {
+	str, isString := value.(string)
+	str = strings.TrimSpace(str)
+	switch typ {
+	case "int":
+		if f, ok := value.(float64); ok {
+			if f != math.Trunc(f) {
+				return nil, errors.New("not an integer")
+			}
+			return int(f), nil
+		}
+		if !isString {
+			return nil, errors.New("unsupported value")
+		}
+		return strconv.Atoi(str)
+	case "float":
+		if f, ok := value.(float64); ok {
+			return f, nil
+		}
+		if !isString {
+			return nil, errors.New("unsupported value")
+		}
+		return strconv.ParseFloat(str, 64)
+	case "bool":
+		if b, ok := value.(bool); ok {
+			return b, nil
+		}
+		if !isString {
+			return nil, errors.New("unsupported value")
+		}
+		return strconv.ParseBool(strings.ToLower(str))
+	case "json":
+		if !isString {
+			return value, nil
+		}
+		var obj interface{}
+		if err := json.Unmarshal([]byte(str), &obj); err != nil {
+			return nil, err
+		}
+		return obj, nil
+	case "string":
+		if isString {
+			return value, nil
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	default:
+		return nil, errors.New("unknown type")
+	}
+}
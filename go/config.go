@@ -0,0 +1,118 @@
+package structuredparse
+
+import (
+	"fmt"
+	"maps"
+	"reflect"
+)
+
+// ParserConfig is a serializable description of a parser: its labels (with
+// original names) and resolved options. It can be stored as JSON and turned
+// back into an equivalent parser with NewParserFromConfig.
+type ParserConfig struct {
+	Labels  []Label       `json:"labels"`
+	Options ParserOptions `json:"options"`
+}
+
+// Config returns the parser's configuration as plain data.
+func (p *Parser) Config() ParserConfig {
+	return ParserConfig{
+		Labels:  p.originalLabels(),
+		Options: p.opts,
+	}
+}
+
+// NewParserFromConfig creates a new Parser from a configuration produced by
+// Config (or decoded from its JSON form).
+func NewParserFromConfig(c ParserConfig) (*Parser, error) {
+	opts := c.Options
+	return NewParser(c.Labels, &opts)
+}
+
+// ConfigEqual reports whether two parsers were built from equivalent labels
+// and options.
+func (p *Parser) ConfigEqual(other *Parser) bool {
+	return len(p.ConfigDiff(other)) == 0
+}
+
+// ConfigDiff describes each difference between the labels, separators, and
+// options of two parsers, one message per differing field. Labels are compared
+// by position using their original names. Function-valued options can only be
+// compared for presence, so two parsers with different non-nil callbacks are
+// reported as equal.
+func (p *Parser) ConfigDiff(other *Parser) []string {
+	var diffs []string
+	if other == nil {
+		return []string{"other parser is nil"}
+	}
+
+	mine, theirs := p.originalLabels(), other.originalLabels()
+	if len(mine) != len(theirs) {
+		diffs = append(diffs, fmt.Sprintf("label count differs: %d vs %d", len(mine), len(theirs)))
+	}
+	for i := 0; i < len(mine) && i < len(theirs); i++ {
+		for _, d := range diffFields(mine[i], theirs[i]) {
+			diffs = append(diffs, fmt.Sprintf("label[%d] (%s): %s", i, mine[i].Name, d))
+		}
+	}
+
+	for _, d := range diffFields(p.opts, other.opts) {
+		diffs = append(diffs, "options: "+d)
+	}
+	return diffs
+}
+
+// Labels returns the parser's labels as declared, with their original names
+// and Meta, in declaration order. The labels are copies with cloned Meta
+// maps, so modifying them does not affect the parser.
+func (p *Parser) Labels() []Label {
+	labels := p.originalLabels()
+	for i := range labels {
+		labels[i].Meta = maps.Clone(labels[i].Meta)
+	}
+	return labels
+}
+
+// originalLabels returns a copy of the parser's labels with their original names.
+func (p *Parser) originalLabels() []Label {
+	labels := make([]Label, len(p.labels))
+	copy(labels, p.labels)
+	for i := range labels {
+		if name := p.originalNames[labels[i].Name]; name != "" {
+			labels[i].Name = name
+		}
+	}
+	return labels
+}
+
+// diffFields compares two structs of the same type field by field, returning
+// a message for each exported field whose values differ.
+func diffFields(a, b interface{}) []string {
+	var diffs []string
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < av.NumField(); i++ {
+		field := av.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		af, bf := av.Field(i), bv.Field(i)
+		if field.Type.Kind() == reflect.Func {
+			if af.IsNil() != bf.IsNil() {
+				diffs = append(diffs, fmt.Sprintf("%s differs: %s vs %s", field.Name, funcState(af), funcState(bf)))
+			}
+			continue
+		}
+		if !reflect.DeepEqual(af.Interface(), bf.Interface()) {
+			diffs = append(diffs, fmt.Sprintf("%s differs: %v vs %v", field.Name, af.Interface(), bf.Interface()))
+		}
+	}
+	return diffs
+}
+
+// funcState describes whether a function value is set.
+func funcState(v reflect.Value) string {
+	if v.IsNil() {
+		return "unset"
+	}
+	return "set"
+}
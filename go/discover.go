@@ -0,0 +1,44 @@
+package structuredparse
+
+import (
+	"regexp"
+	"strings"
+)
+
+// discoverLineRe matches an unindented "Label: value" line whose label is one
+// to four words starting with a letter.
+var discoverLineRe = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9_]*(?: [A-Za-z0-9_]+){0,3})\s*:(?:\s+(.*))?$`)
+
+// DiscoverLabels suggests a label configuration from sample output. Every
+// unindented line of the form "Name: value" contributes a label, deduplicated
+// case-insensitively and kept in order of first appearance with the casing
+// first seen. A label whose values all start with '{' or '[' is marked IsJSON.
+// The result is a starting point to refine, not a validated schema.
+func DiscoverLabels(text string) []Label {
+	var labels []Label
+	index := make(map[string]int)
+	// nonJSON records labels with at least one value that is not JSON-like.
+	nonJSON := make(map[string]bool)
+	hasValue := make(map[string]bool)
+
+	for _, line := range splitAndTrimLines(cleanText(text)) {
+		m := discoverLineRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		name, value := m[1], strings.TrimSpace(m[2])
+		key := strings.ToLower(name)
+		if _, ok := index[key]; !ok {
+			index[key] = len(labels)
+			labels = append(labels, Label{Name: name})
+		}
+		if value != "" {
+			hasValue[key] = true
+			nonJSON[key] = nonJSON[key] || (value[0] != '{' && value[0] != '[')
+		}
+	}
+	for key, i := range index {
+		labels[i].IsJSON = hasValue[key] && !nonJSON[key]
+	}
+	return labels
+}
@@ -0,0 +1,168 @@
+package structuredparse
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Sentinel errors identifying each kind of parse error. A *ParseError matches
+// the sentinel for its code under errors.Is.
+var (
+	ErrRequired          = errors.New("required label missing")
+	ErrRequiredWith      = errors.New("required dependency missing")
+	ErrJSON              = errors.New("invalid JSON")
+	ErrNoBlockStart      = errors.New("no block start label defined")
+	ErrLineTooLong       = errors.New("line exceeds maximum length")
+	ErrNotRepeatable     = errors.New("label is not repeatable")
+	ErrDuplicateBlockKey = errors.New("duplicate block key")
+	ErrMissingBlockKey   = errors.New("block key missing")
+	ErrAmbiguousMatch    = errors.New("line matches multiple labels")
+	ErrUnitParse         = errors.New("value is not a number with a unit")
+	ErrEnum              = errors.New("value not in enum")
+	ErrInterleavedField  = errors.New("field interrupted by another field")
+	ErrTooManyErrors     = errors.New("too many errors")
+	ErrRecommended       = errors.New("recommended label missing")
+	ErrConflict          = errors.New("conflicting labels present")
+	ErrCount             = errors.New("wrong number of occurrences")
+	ErrOrder             = errors.New("fields out of order")
+	ErrJSONDepth         = errors.New("JSON nested too deeply")
+	ErrUnknownJSONKey    = errors.New("unknown JSON key")
+)
+
+// ErrorCode classifies a ParseError.
+type ErrorCode string
+
+const (
+	CodeRequired          ErrorCode = "required"
+	CodeRequiredWith      ErrorCode = "required_with"
+	CodeJSON              ErrorCode = "json"
+	CodeNoBlockStart      ErrorCode = "no_block_start"
+	CodeLineTooLong       ErrorCode = "line_too_long"
+	CodeNotRepeatable     ErrorCode = "not_repeatable"
+	CodeDuplicateBlockKey ErrorCode = "duplicate_block_key"
+	CodeMissingBlockKey   ErrorCode = "missing_block_key"
+	CodeAmbiguousMatch    ErrorCode = "ambiguous_match"
+	CodeUnitParse         ErrorCode = "unit_parse"
+	CodeEnum              ErrorCode = "enum"
+	CodeInterleavedField  ErrorCode = "interleaved_field"
+	CodeTooManyErrors     ErrorCode = "too_many_errors"
+	CodeRecommended       ErrorCode = "recommended"
+	CodeConflict          ErrorCode = "conflict"
+	CodeCount             ErrorCode = "count"
+	CodeOrder             ErrorCode = "order"
+	CodeJSONDepth         ErrorCode = "json_depth"
+	CodeUnknownJSONKey    ErrorCode = "unknown_json_key"
+)
+
+// sentinels maps each error code to its sentinel error.
+var sentinels = map[ErrorCode]error{
+	CodeRequired:          ErrRequired,
+	CodeRequiredWith:      ErrRequiredWith,
+	CodeJSON:              ErrJSON,
+	CodeNoBlockStart:      ErrNoBlockStart,
+	CodeLineTooLong:       ErrLineTooLong,
+	CodeNotRepeatable:     ErrNotRepeatable,
+	CodeDuplicateBlockKey: ErrDuplicateBlockKey,
+	CodeMissingBlockKey:   ErrMissingBlockKey,
+	CodeAmbiguousMatch:    ErrAmbiguousMatch,
+	CodeUnitParse:         ErrUnitParse,
+	CodeEnum:              ErrEnum,
+	CodeInterleavedField:  ErrInterleavedField,
+	CodeTooManyErrors:     ErrTooManyErrors,
+	CodeRecommended:       ErrRecommended,
+	CodeConflict:          ErrConflict,
+	CodeCount:             ErrCount,
+	CodeOrder:             ErrOrder,
+	CodeJSONDepth:         ErrJSONDepth,
+	CodeUnknownJSONKey:    ErrUnknownJSONKey,
+}
+
+// ParseError is a structured error produced while parsing or validating.
+// Its Error method returns the same message the string-based API reports.
+type ParseError struct {
+	Code       ErrorCode // Kind of error
+	Label      string    // Original name of the label the error concerns, if any
+	Dependency string    // For CodeRequiredWith, the dependency as declared in RequiredWith
+	Message    string    // Human-readable message
+	Detail     string    // For validation errors, what the parser observed for the referenced label
+	Err        error     // Underlying cause, such as a JSON syntax error
+	Warning    bool      // Whether this is an advisory diagnostic rather than a failure
+}
+
+// Error returns the human-readable error message.
+func (e *ParseError) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is the sentinel error for e's code.
+func (e *ParseError) Is(target error) bool {
+	sentinel, ok := sentinels[e.Code]
+	return ok && target == sentinel
+}
+
+// Unwrap returns the underlying cause, if any.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// limitErrors truncates errs to MaxErrors entries, replacing the remainder
+// with a single summary error. It is applied once by each public entry point.
+func (p *Parser) limitErrors(errs []*ParseError) []*ParseError {
+	limit := p.opts.MaxErrors
+	if limit <= 0 || len(errs) <= limit {
+		return errs
+	}
+	more := len(errs) - limit
+	noun := " more errors"
+	if more == 1 {
+		noun = " more error"
+	}
+	return append(errs[:limit:limit], &ParseError{
+		Code:    CodeTooManyErrors,
+		Message: "...and " + strconv.Itoa(more) + noun,
+	})
+}
+
+// errorStrings converts structured errors to their messages.
+func errorStrings(errs []*ParseError) []string {
+	errList := make([]string, 0, len(errs))
+	for _, e := range errs {
+		errList = append(errList, e.Message)
+	}
+	return errList
+}
+
+// JoinErrors combines error strings, as returned by Parse and the other
+// string-based entry points, into a single error. It returns nil when errs is
+// empty. The joined error's message is the errors separated by newlines, and
+// its Unwrap method returns one error per entry.
+func JoinErrors(errs []string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	joined := &joinedError{errs: make([]error, len(errs))}
+	for i, msg := range errs {
+		joined.errs[i] = errors.New(msg)
+	}
+	return joined
+}
+
+// joinedError is the error returned by JoinErrors.
+type joinedError struct {
+	errs []error
+}
+
+// Error returns the joined messages, one per line.
+func (e *joinedError) Error() string {
+	msgs := make([]string, len(e.errs))
+	for i, err := range e.errs {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the individual errors.
+func (e *joinedError) Unwrap() []error {
+	return e.errs
+}
@@ -0,0 +1,72 @@
+package structuredparse
+
+import (
+	"regexp"
+	"strings"
+)
+
+// buildCandidateRegex creates a regex capturing the text before the first
+// separator run on a line (group 1), used as a candidate label for fuzzy
+// matching, and the separator run itself (see separatorRun).
+func buildCandidateRegex(opts ParserOptions) *regexp.Regexp {
+	class := separatorClass(opts.Separators)
+	if opts.TreatTabAsSeparator {
+		// Prepended: the class ends with a literal dash when one is a separator.
+		class = `\t` + class
+	}
+	return regexp.MustCompile(`^\s*([^` + class + `]+?)` + separatorRun(opts))
+}
+
+// fuzzyMatch matches the text before the line's separator against declared
+// labels, tolerating up to FuzzyLabelDistance edits. The closest label wins;
+// ties go to the label declared first. It returns the canonical (lowercase)
+// label name and the value following the separator.
+func (p *Parser) fuzzyMatch(line string) (string, string, bool) {
+	loc := p.candidateRe.FindStringSubmatchIndex(line)
+	if loc == nil {
+		return "", "", false
+	}
+	candidate := strings.ToLower(strings.Join(strings.Fields(line[loc[2]:loc[3]]), " "))
+	if candidate == "" {
+		return "", "", false
+	}
+
+	best := ""
+	bestDistance := p.opts.FuzzyLabelDistance + 1
+	for _, lbl := range p.labels {
+		if lbl.Computed != "" || lbl.RequireExactCase {
+			continue
+		}
+		name := strings.Join(strings.Fields(lbl.Name), " ")
+		if d := levenshtein(candidate, name); d < bestDistance {
+			best = lbl.Name
+			bestDistance = d
+		}
+	}
+	if best == "" {
+		return "", "", false
+	}
+	return best, strings.TrimSpace(line[loc[1]:]), true
+}
+
+// levenshtein returns the edit distance between two strings, counted in runes.
+func levenshtein(a, b string) int {
+	ar, br := []rune(a), []rune(b)
+	prev := make([]int, len(br)+1)
+	curr := make([]int, len(br)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ar); i++ {
+		curr[0] = i
+		for j := 1; j <= len(br); j++ {
+			cost := 1
+			if ar[i-1] == br[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(br)]
+}
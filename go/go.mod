@@ -1,3 +1,5 @@
 module github.com/hlfshell/structured-parse/go
 
 go 1.24.2
+
+require google.golang.org/protobuf v1.36.12
@@ -5,17 +5,166 @@ package structuredparse
 
 import (
 	"errors"
+	"maps"
 	"regexp"
+	"slices"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Label defines a label for parsing with options for required, dependencies, JSON, and block start.
 type Label struct {
-	Name         string   // Name of the label (case-insensitive matching, but original casing preserved in results)
-	Required     bool     // Whether this label is required
-	RequiredWith []string // List of other label names required with this one
-	IsJSON       bool     // Whether this label should be parsed as JSON
-	IsBlockStart bool     // Whether this label starts a new block
+	Name         string   `json:"name"`                   // Name of the label (case-insensitive matching, but original casing preserved in results)
+	Required     bool     `json:"required,omitempty"`     // Whether this label is required
+	RequiredWith []string `json:"requiredWith,omitempty"` // List of other label names required with this one
+	IsJSON       bool     `json:"isJson,omitempty"`       // Whether this label should be parsed as JSON
+	IsBlockStart bool     `json:"isBlockStart,omitempty"` // Whether this label starts a new block
+	Repeatable   bool     `json:"repeatable,omitempty"`   // Whether this label may repeat; if set, its value is always a slice
+	NestedLabels []Label  `json:"nestedLabels,omitempty"` // Labels parsed from indented continuation lines into a nested map
+
+	// StopAtBlankLine ends this label's value at the first blank line instead of
+	// the next recognized label; label-looking lines before it are kept as text.
+	StopAtBlankLine bool `json:"stopAtBlankLine,omitempty"`
+
+	// Dedent removes the longest common leading whitespace from every line of
+	// the value while keeping relative indentation, for code or poetry fields.
+	Dedent bool `json:"dedent,omitempty"`
+
+	// UnlessPresent lists labels whose presence suppresses this label's
+	// Required and RequiredWith checks, modeling either-or branches such as
+	// an agent emitting either an Action or a Final Answer.
+	UnlessPresent []string `json:"unlessPresent,omitempty"`
+
+	// BlockFields, on a block start label, lists the labels recognized inside
+	// blocks it starts; other labels are treated as plain text there. When every
+	// block start label declares BlockFields, several may be defined, giving
+	// each block type its own schema.
+	BlockFields []string `json:"blockFields,omitempty"`
+
+	// IncludeLabelInValue stores the whole matched line, label and separator
+	// included, as the start of the value, reproducing the output verbatim.
+	// It is meant for text labels; combined with IsJSON the value is invalid.
+	IncludeLabelInValue bool `json:"includeLabelInValue,omitempty"`
+
+	// ParseUnit splits a value such as "22C" or "5 MB" into a numeric part and
+	// a trailing unit token, producing {"value": 22, "unit": "C"} (the value
+	// is a float64, as for JSON numbers). Values that do not fit are kept as
+	// plain strings and reported with a warning.
+	ParseUnit bool `json:"parseUnit,omitempty"`
+
+	// DedupeValues drops repeated occurrences whose parsed value equals an
+	// earlier one, keeping the first in order. Deduplication happens before
+	// DuplicatePolicy applies, so an echoed identical value is not a duplicate.
+	DedupeValues bool `json:"dedupeValues,omitempty"`
+
+	// NormalizeCase folds the case of non-JSON values: "upper", "lower",
+	// "title" (each word capitalized), or "none"/"" to leave them unchanged.
+	// It applies to every entry of a repeated label and to each item of a
+	// SplitOn or ParagraphsAsArray list; JSON and Opaque values are untouched.
+	NormalizeCase string `json:"normalizeCase,omitempty"`
+
+	// Enum restricts non-JSON values to the listed options, compared
+	// case-insensitively; other values are reported as errors. When
+	// NormalizeCase is empty, a matching value takes the casing declared in
+	// Enum; "none" keeps it as written.
+	Enum []string `json:"enum,omitempty"`
+
+	// ValueOnNextLine handles output that puts the value on the line after
+	// the label ("Action:\n run"): when the label line has no value, the next
+	// non-blank line is always part of the value, even if it looks like a
+	// label. Without it such a line still becomes the value unless it matches
+	// a declared label.
+	ValueOnNextLine bool `json:"valueOnNextLine,omitempty"`
+
+	// FlagOnly makes the label a presence flag: its result is true when it
+	// appears, as "Verified:" or just "Verified" on its own line, and false
+	// otherwise. Any value text is ignored.
+	FlagOnly bool `json:"flagOnly,omitempty"`
+
+	// Computed makes the label a derived field: instead of being read from
+	// the input, its value is the template with each {Name} placeholder
+	// replaced by that field's value (case-insensitive; unknown or absent
+	// fields give ""). Computed labels are evaluated after all other fields,
+	// in declaration order, so they may reference earlier computed labels.
+	Computed string `json:"computed,omitempty"`
+
+	// ParagraphsAsArray splits the value on blank lines, producing a []string
+	// with one trimmed element per paragraph, for step-by-step reasoning
+	// fields. It has no effect on JSON or nested labels, or together with
+	// StopAtBlankLine, which ends the value at the first blank line.
+	ParagraphsAsArray bool `json:"paragraphsAsArray,omitempty"`
+
+	// Recommended reports a missing label with a warning instead of the error
+	// Required gives. UnlessPresent suppresses it like Required.
+	Recommended bool `json:"recommended,omitempty"`
+
+	// MinCount and MaxCount bound how many times the label may appear with a
+	// value, such as between 1 and 10 "Step" entries; zero means no bound.
+	// They are meant for Repeatable labels.
+	MinCount int `json:"minCount,omitempty"`
+	MaxCount int `json:"maxCount,omitempty"`
+
+	// SplitOn splits a non-JSON value on this delimiter (such as ",") into a
+	// []string of trimmed elements, dropping empty ones, for flat lists like
+	// "Tags: a, b, c". Enum, if set, is checked for each element.
+	SplitOn string `json:"splitOn,omitempty"`
+
+	// Opaque stores the value verbatim, as key:value-like text such as
+	// "Content-Type: application/json" often needs: only surrounding
+	// whitespace is trimmed, and settings that transform values (IsJSON,
+	// NestedLabels, Dedent, NormalizeCase, Enum, ParseUnit, SplitOn,
+	// ParagraphsAsArray) are ignored, as is a repeated separator ("X: : y").
+	Opaque bool `json:"opaque,omitempty"`
+
+	// AllowEmpty makes the label count as present whenever it appears, even
+	// with an empty value ("Notes:"), for Required, RequiredWith and every
+	// other check that asks whether a label is missing.
+	AllowEmpty bool `json:"allowEmpty,omitempty"`
+
+	// RequireExactCase makes the label match only when written with the
+	// casing of its declaration ("Action:" but not "action:"), so that
+	// lowercase prose is not mistaken for it. Fuzzy matching skips it, and
+	// GlobalAliases for it must match as written too.
+	RequireExactCase bool `json:"requireExactCase,omitempty"`
+
+	// Meta holds arbitrary data for downstream use, such as a display name or
+	// help text for a form. The parser ignores it but returns it from Labels.
+	Meta map[string]string `json:"meta,omitempty"`
+
+	// NumberedSuffix lets the label also match with a number after it, as in
+	// "Step 1:", "Step 2:" or "Step #3:", collecting every occurrence into a
+	// slice in order of appearance as if Repeatable were set. The numbers are
+	// not checked; ClassifyLine reports them as part of AsWritten.
+	NumberedSuffix bool `json:"numberedSuffix,omitempty"`
+
+	// JSONTarget, when set on a JSON label, is a value whose type the label's
+	// JSON is decoded into, e.g. Config{} or &Config{}. The result then holds a
+	// value of that same type instead of a generic map. It cannot be set from
+	// the WASM bindings.
+	JSONTarget interface{} `json:"-"`
+
+	// DisallowUnknownJSONKeys rejects JSON objects with keys that have no
+	// matching field in JSONTarget, reporting the first such key as an error.
+	DisallowUnknownJSONKeys bool `json:"-"`
+
+	// TrimTrailing lists characters stripped from the right end of a text
+	// value, such as ".!" to turn "done!" into "done". It applies after
+	// Dedent and NormalizeCase and before Enum matching, and whitespace
+	// exposed by the trim is dropped too.
+	TrimTrailing string `json:"trimTrailing,omitempty"`
+
+	// PreserveIndent keeps the leading whitespace of a value's first line,
+	// as when a "Stack Trace:" label line is followed by indented frames, so
+	// the value keeps its relative structure. Only blank lines are trimmed
+	// from either end.
+	PreserveIndent bool `json:"preserveIndent,omitempty"`
+}
+
+// repeats reports whether the label's values are always collected into a
+// slice.
+func (l Label) repeats() bool {
+	return l.Repeatable || l.NumberedSuffix
 }
 
 type labelPattern struct {
@@ -23,14 +172,227 @@ type labelPattern struct {
 	Name string
 	// Regex pattern for the label
 	Pattern *regexp.Regexp
+	// First word of the label as matched, case-folded (see foldString), or ""
+	// if the name has none
+	firstWord string
+}
+
+// DuplicatePolicy controls how repeated occurrences of a non-repeatable label are handled.
+type DuplicatePolicy int
+
+const (
+	// DuplicateCollect collects every occurrence into a slice with a warning (the default).
+	DuplicateCollect DuplicatePolicy = iota
+	// DuplicateKeepFirst records an error and keeps only the first occurrence.
+	DuplicateKeepFirst
+	// DuplicateKeepLast records an error and keeps only the last occurrence.
+	DuplicateKeepLast
+)
+
+// duplicatePolicyNames maps each DuplicatePolicy to its serialized name.
+var duplicatePolicyNames = map[DuplicatePolicy]string{
+	DuplicateCollect:   "collect",
+	DuplicateKeepFirst: "first",
+	DuplicateKeepLast:  "last",
+}
+
+// MarshalText encodes the policy as "collect", "first", or "last".
+func (d DuplicatePolicy) MarshalText() ([]byte, error) {
+	name, ok := duplicatePolicyNames[d]
+	if !ok {
+		return nil, errors.New("unknown duplicate policy")
+	}
+	return []byte(name), nil
+}
+
+// UnmarshalText decodes a policy encoded by MarshalText.
+func (d *DuplicatePolicy) UnmarshalText(text []byte) error {
+	for policy, name := range duplicatePolicyNames {
+		if name == string(text) {
+			*d = policy
+			return nil
+		}
+	}
+	return errors.New("unknown duplicate policy: " + string(text))
 }
 
 // ParserOptions allows customization of parser behavior.
 type ParserOptions struct {
 	// Separators is a string containing the allowed separator characters.
 	// Default is ":~-=" (colon, tilde, dash, equals).
-	// Each character in the string is treated as a valid separator.
-	Separators string
+	// Each character in the string is treated as a valid separator; multi-byte
+	// characters such as "→" are matched as whole runes.
+	Separators string `json:"separators,omitempty"`
+
+	// RequireSpaceAfterSeparator requires the separator run to be followed by
+	// whitespace (or the end of the line) for a label to match. This lets
+	// "Time: 3:30" match while "Time:3:30" is treated as plain text.
+	RequireSpaceAfterSeparator bool `json:"requireSpaceAfterSeparator,omitempty"`
+
+	// ImplicitFirstBlock makes ParseBlocks treat content before the first
+	// block start label as block 0, rescuing output where the model omitted
+	// the start label on the first block only. The leading content is only
+	// kept if it contains at least one recognized label.
+	ImplicitFirstBlock bool `json:"implicitFirstBlock,omitempty"`
+
+	// FuzzyLabelDistance enables tolerant label matching: when no declared
+	// label matches a line exactly, the text before its separator is matched
+	// against the declared labels allowing up to this many single-character
+	// edits (Levenshtein distance). The closest label wins and ties go to the
+	// label declared first. Results are keyed by the canonical label name.
+	// Zero (the default) disables fuzzy matching.
+	FuzzyLabelDistance int `json:"fuzzyLabelDistance,omitempty"`
+
+	// BlockIntroKey, when set, makes ParseBlocks store the non-label content
+	// immediately following a block's start line under this key in the block
+	// map, instead of folding it into the block start field's value.
+	BlockIntroKey string `json:"blockIntroKey,omitempty"`
+
+	// MaxLineLength guards against degenerate single-line inputs: lines longer
+	// than this many bytes are truncated before label matching. Zero (the
+	// default) means unlimited.
+	MaxLineLength int `json:"maxLineLength,omitempty"`
+
+	// ErrorOnLongLine additionally records an error for each line truncated
+	// by MaxLineLength.
+	ErrorOnLongLine bool `json:"errorOnLongLine,omitempty"`
+
+	// DuplicatePolicy decides what happens when a label without Repeatable set
+	// appears more than once. The default, DuplicateCollect, keeps the
+	// historical behavior of collecting all values into a slice, reported as a
+	// warning.
+	DuplicatePolicy DuplicatePolicy `json:"duplicatePolicy,omitempty"`
+
+	// NormalizeUnicodePunctuation converts curly quotes to straight quotes and
+	// unicode dashes to hyphens in IsJSON values that fail to unmarshal as
+	// written, then retries. Valid JSON and other fields keep their original
+	// typography. On failure the raw (unnormalized) value is kept.
+	NormalizeUnicodePunctuation bool `json:"normalizeUnicodePunctuation,omitempty"`
+
+	// JSONRepair, when set, is called with the label's original name and raw
+	// value whenever an IsJSON value fails to unmarshal. If it returns true the
+	// returned string is unmarshaled instead; the JSON error is only recorded
+	// if repair is declined or the repaired text is still invalid. It is not
+	// serialized with the parser config.
+	JSONRepair func(label, raw string) (string, bool) `json:"-"`
+
+	// EmptyJSONAsNull makes an IsJSON label that appears without a value
+	// produce nil instead of an empty object. Such a null-but-present field
+	// counts as present for Required and RequiredWith checks; an absent JSON
+	// label is still "" and still counts as missing.
+	EmptyJSONAsNull bool `json:"emptyJsonAsNull,omitempty"`
+
+	// WarnAmbiguousMatches records a warning (a ParseError with Warning set,
+	// reported in the error list with a "warning:" prefix) whenever more than
+	// one label pattern matches the same line, naming the competing labels and
+	// the winner. This surfaces overlapping or duplicated label definitions.
+	WarnAmbiguousMatches bool `json:"warnAmbiguousMatches,omitempty"`
+
+	// TreatTabAsSeparator lets a tab alone separate a label from its value
+	// ("Action\trun"). The tab already counts as the whitespace demanded by
+	// RequireSpaceAfterSeparator. A tab may also be listed in Separators, but
+	// then RequireSpaceAfterSeparator needs further whitespace after it.
+	TreatTabAsSeparator bool `json:"treatTabAsSeparator,omitempty"`
+
+	// BlockSeparatorPattern is a regular expression matched against each
+	// trimmed line in ParseBlocks; matching lines (such as "^-{3,}$") end the
+	// current block and start a new one, and are not part of any block. It
+	// works alongside a block start label or on its own, in which case the
+	// content before the first divider forms the first block.
+	BlockSeparatorPattern string `json:"blockSeparatorPattern,omitempty"`
+
+	// DisallowInterleavedFields reports an error when a label reappears after a
+	// different label has started (e.g. Thought, Action, Thought), which usually
+	// means scrambled output. Repeatable labels are exempt, since they are
+	// expected to alternate with others.
+	DisallowInterleavedFields bool `json:"disallowInterleavedFields,omitempty"`
+
+	// MaxErrors caps the number of errors (warnings included) returned by a
+	// parse; the rest are replaced by a final "...and N more errors" entry.
+	// Zero means unlimited.
+	MaxErrors int `json:"maxErrors,omitempty"`
+
+	// StripLabelPrefixRunes lists decorative runes, such as emoji icons, that
+	// are removed (along with whitespace) from the start of a line before label
+	// matching, so "🧠 Thought:" matches Thought. Include any variation
+	// selector that accompanies an icon (as in "⚙️").
+	StripLabelPrefixRunes string `json:"stripLabelPrefixRunes,omitempty"`
+
+	// GlobalAliases maps alternative label names to declared label names
+	// (both case-insensitive), such as "Final Response" → "Final Answer", so one
+	// alias table can be shared across parsers. A line starting with an alias
+	// is read as the canonical label and keyed by its name in results. Aliases
+	// whose target is not declared in a parser are ignored by it. Labels have
+	// no aliases of their own; an alias equal to a declared label name is
+	// ignored, and otherwise aliases compete with label names like labels do
+	// with each other: the longest match wins, then declared names.
+	GlobalAliases map[string]string `json:"globalAliases,omitempty"`
+
+	// BlockStartAsKey lets ParseBlocksByKey be called with an empty keyField to
+	// index blocks by the value of the block start label that opened each one
+	// (whichever it is when several are declared). That field is then removed
+	// from the block, since the map key already holds it; pass the label's name
+	// as keyField instead to keep it. Other block methods are unaffected.
+	BlockStartAsKey bool `json:"blockStartAsKey,omitempty"`
+
+	// AgentMode adds the usual ReAct constraints among the declared labels
+	// named Action, Action Input and Final Answer (matched case-insensitively):
+	// Action requires Action Input, and Action and Final Answer cannot both
+	// appear, since a step either acts or answers. Constraints involving an
+	// undeclared label are skipped.
+	AgentMode bool `json:"agentMode,omitempty"`
+
+	// FlexibleWordSeparators lets underscores and hyphens stand in for the
+	// whitespace between the words of a multi-word label, so "Action_Input:" and
+	// "Action-Input:" match "Action Input".
+	FlexibleWordSeparators bool `json:"flexibleWordSeparators,omitempty"`
+
+	// SplitInlineLabels splits a label line on which the model ran several
+	// fields together ("Thought: x Action: run") into separate fields: whenever
+	// a declared label and its separator follow whitespace within the value,
+	// the value ends there and the rest is read as a new label line. Matching
+	// is case-insensitive as usual, so prose such as "the action: unclear" is
+	// split too. Continuation lines and the values of IsJSON, Opaque and
+	// IncludeLabelInValue labels are never split, nor is text inside an
+	// escape fence. Block boundaries are still only detected at line starts.
+	SplitInlineLabels bool `json:"splitInlineLabels,omitempty"`
+
+	// EnforceOrder reports an error for each label whose first appearance
+	// comes after that of a label declared later, catching output that
+	// scrambles the expected field sequence. Labels that never appear are not
+	// considered, so optional fields may be skipped.
+	EnforceOrder bool `json:"enforceOrder,omitempty"`
+
+	// SkipPreambleUntilFirstLabel discards every line before the first line
+	// that starts with a recognized label, such as a chatty "Sure, here's the
+	// output:" introduction, so it can neither open an escape fence nor form a
+	// block under ImplicitFirstBlock or BlockSeparatorPattern. In ParseBlocks
+	// the same applies within each block.
+	SkipPreambleUntilFirstLabel bool `json:"skipPreambleUntilFirstLabel,omitempty"`
+
+	// MaxJSONDepth limits how deeply objects and arrays may nest in an IsJSON
+	// value; deeper values are rejected with an error before unmarshaling, to
+	// guard against degenerate untrusted output. Zero means the default limit
+	// of 100, and a negative value disables the check.
+	MaxJSONDepth int `json:"maxJsonDepth,omitempty"`
+
+	// SkipEmptyBlocks drops blocks that contain the block start label and no
+	// other field, as happens when two block start lines appear back to back or
+	// the text ends with one. By default such blocks are emitted, holding just
+	// the block start value, with any missing required fields reported.
+	SkipEmptyBlocks bool `json:"skipEmptyBlocks,omitempty"`
+
+	// FallbackSingleBlock makes ParseBlocks return the whole input as a single
+	// block, parsed with every label, when no block starts at all. By default
+	// such input yields no blocks (or only an ImplicitFirstBlock one).
+	FallbackSingleBlock bool `json:"fallbackSingleBlock,omitempty"`
+
+	// UnwrapOuterFenceOnly limits code markup removal to a single markdown fence
+	// enclosing the whole text, for models that wrap their entire response in
+	// one. Fences and inline code inside it are left intact, so code examples in
+	// values survive; a JSON value that is itself fenced is unwrapped before
+	// decoding. By default every fence and inline code span is unwrapped.
+	UnwrapOuterFenceOnly bool `json:"unwrapOuterFenceOnly,omitempty"`
 }
 
 // NewParser creates a new Parser with the given labels and optional options.
@@ -42,6 +404,7 @@ func NewParser(labels []Label, opts *ParserOptions) (*Parser, error) {
 	labelMap := make(map[string]Label)
 	originalNames := make(map[string]string)
 	blockStartCount := 0
+	allScoped := true
 
 	for i := range internalLabels {
 		originalName := internalLabels[i].Name
@@ -51,58 +414,529 @@ func NewParser(labels []Label, opts *ParserOptions) (*Parser, error) {
 		labelMap[lowerName] = internalLabels[i]
 		originalNames[lowerName] = originalName
 
+		switch internalLabels[i].NormalizeCase {
+		case "", "none", "upper", "lower", "title":
+		default:
+			return nil, errors.New("label '" + originalName + "': unknown normalizeCase '" + internalLabels[i].NormalizeCase + "'")
+		}
+
+		if maxCount := internalLabels[i].MaxCount; maxCount > 0 && internalLabels[i].MinCount > maxCount {
+			return nil, errors.New("label '" + originalName + "': minCount exceeds maxCount")
+		}
+
+		if internalLabels[i].JSONTarget != nil && !internalLabels[i].IsJSON {
+			return nil, errors.New("label '" + originalName + "': jsonTarget requires isJson")
+		}
+		if internalLabels[i].DisallowUnknownJSONKeys && internalLabels[i].JSONTarget == nil {
+			return nil, errors.New("label '" + originalName + "': disallowUnknownJsonKeys requires jsonTarget")
+		}
+
 		if internalLabels[i].IsBlockStart {
 			blockStartCount++
+			allScoped = allScoped && len(internalLabels[i].BlockFields) > 0
 		}
 	}
 
-	if blockStartCount > 1 {
+	if blockStartCount > 1 && !allScoped {
 		return nil, errors.New("only one block start label is allowed")
 	}
 
-	separators := ":~-="
-	if opts != nil && opts.Separators != "" {
-		separators = opts.Separators
+	resolved := defaultOptions().merge(opts)
+	separators := resolved.Separators
+	if err := checkNameSeparators(internalLabels, originalNames, resolved); err != nil {
+		return nil, err
 	}
 
-	patterns := buildPatterns(internalLabels, separators)
-	separatorRegex := buildSeparatorRegex(separators)
+	patterns := buildPatterns(internalLabels, originalNames, resolved)
+	separatorRegex := buildSeparatorRegex(resolved)
+	candidateRegex := buildCandidateRegex(resolved)
+
+	var blockSepRegex *regexp.Regexp
+	if resolved.BlockSeparatorPattern != "" {
+		var err error
+		blockSepRegex, err = regexp.Compile(resolved.BlockSeparatorPattern)
+		if err != nil {
+			return nil, errors.New("invalid block separator pattern: " + err.Error())
+		}
+	}
+
+	nested := make(map[string]*Parser)
+	for _, label := range internalLabels {
+		if len(label.NestedLabels) == 0 {
+			continue
+		}
+		sub, err := NewParser(label.NestedLabels, &resolved)
+		if err != nil {
+			return nil, errors.New("nested labels of '" + originalNames[label.Name] + "': " + err.Error())
+		}
+		nested[label.Name] = sub
+	}
+
+	blockParsers := make(map[string]*Parser)
+	for _, label := range internalLabels {
+		if !label.IsBlockStart || len(label.BlockFields) == 0 {
+			continue
+		}
+		names := append([]string{label.Name}, label.BlockFields...)
+		scoped := make([]Label, 0, len(names))
+		seen := make(map[string]bool, len(names))
+		for _, field := range names {
+			lowerField := strings.ToLower(field)
+			if _, ok := labelMap[lowerField]; !ok {
+				return nil, errors.New("block fields of '" + originalNames[label.Name] + "': unknown label '" + field + "'")
+			}
+			if seen[lowerField] {
+				continue
+			}
+			seen[lowerField] = true
+			// Scoped copies drop block settings: the sub-parser only parses a
+			// single block's lines and never splits them further.
+			scopedLabel := labels[indexOfLabel(internalLabels, lowerField)]
+			scopedLabel.IsBlockStart = false
+			scopedLabel.BlockFields = nil
+			scoped = append(scoped, scopedLabel)
+		}
+		sub, err := NewParser(scoped, &resolved)
+		if err != nil {
+			return nil, errors.New("block fields of '" + originalNames[label.Name] + "': " + err.Error())
+		}
+		blockParsers[label.Name] = sub
+	}
 
 	return &Parser{
 		labels:        internalLabels,
 		patterns:      patterns,
+		patternIdx:    newPatternIndex(patterns),
 		labelMap:      labelMap,
 		originalNames: originalNames,
 		separators:    separators,
 		separatorRe:   separatorRegex,
+		candidateRe:   candidateRegex,
+		nested:        nested,
+		blockParsers:  blockParsers,
+		blockSepRe:    blockSepRegex,
+		opts:          resolved,
 	}, nil
 }
 
-// buildPatterns constructs regex patterns for each label.
-func buildPatterns(labels []Label, separators string) []labelPattern {
+// subParsersWithSeparators returns copies of the sub-parsers in subs with
+// their separators replaced, leaving subs untouched if any replacement fails.
+func subParsersWithSeparators(subs map[string]*Parser, seps string) (map[string]*Parser, error) {
+	if len(subs) == 0 {
+		return subs, nil
+	}
+	replaced := make(map[string]*Parser, len(subs))
+	for name, sub := range subs {
+		q := *sub
+		if err := q.SetSeparators(seps); err != nil {
+			return nil, err
+		}
+		replaced[name] = &q
+	}
+	return replaced, nil
+}
+
+// checkNameSeparators reports an error for a label or alias name containing
+// one of the separator characters, which would end the label early wherever a
+// line is split at its first separator (fuzzy matching, DiscoverLabels). A
+// dash inside a hyphenated word, as in "Follow-up", is allowed.
+func checkNameSeparators(labels []Label, originalNames map[string]string, opts ParserOptions) error {
+	names := make([]string, 0, len(labels)+len(opts.GlobalAliases))
+	for _, label := range labels {
+		if label.Computed == "" {
+			names = append(names, originalNames[label.Name])
+		}
+	}
+	names = append(names, slices.Sorted(maps.Keys(opts.GlobalAliases))...)
+	for _, name := range names {
+		if sep, found := separatorInName(name, opts.Separators); found {
+			return errors.New("label '" + name + "' contains the separator '" + string(sep) +
+				"'; rename the label or remove '" + string(sep) + "' from Separators")
+		}
+	}
+	return nil
+}
+
+// separatorInName returns the first separator character in name, ignoring
+// dashes between two letters or digits.
+func separatorInName(name, separators string) (rune, bool) {
+	runes := []rune(name)
+	for i, r := range runes {
+		if !strings.ContainsRune(separators, r) {
+			continue
+		}
+		if r == '-' && i > 0 && i < len(runes)-1 && isWordRune(runes[i-1]) && isWordRune(runes[i+1]) {
+			continue
+		}
+		return r, true
+	}
+	return 0, false
+}
+
+// isWordRune reports whether r is a letter or digit.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// indexOfLabel returns the index of the label with the given lowercase name.
+func indexOfLabel(labels []Label, name string) int {
+	for i, label := range labels {
+		if label.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetSeparators replaces the parser's separator characters, recompiling only the
+// label patterns; the label definitions are reused as-is. It must not be called
+// concurrently with parsing on the same Parser.
+func (p *Parser) SetSeparators(seps string) error {
+	if seps == "" {
+		return errors.New("separators must not be empty")
+	}
+	check := p.opts
+	check.Separators = seps
+	if err := checkNameSeparators(p.labels, p.originalNames, check); err != nil {
+		return err
+	}
+	nested, err := subParsersWithSeparators(p.nested, seps)
+	if err != nil {
+		return err
+	}
+	blockParsers, err := subParsersWithSeparators(p.blockParsers, seps)
+	if err != nil {
+		return err
+	}
+	p.nested = nested
+	p.blockParsers = blockParsers
+	p.opts.Separators = seps
+	p.separators = seps
+	p.patterns = buildPatterns(p.labels, p.originalNames, p.opts)
+	p.patternIdx = newPatternIndex(p.patterns)
+	p.separatorRe = buildSeparatorRegex(p.opts)
+	p.candidateRe = buildCandidateRegex(p.opts)
+	return nil
+}
+
+// defaultOptions returns the options used when none are supplied.
+func defaultOptions() ParserOptions {
+	return ParserOptions{
+		Separators: ":~-=",
+	}
+}
+
+// merge returns a copy of o with every non-zero field of override applied.
+func (o ParserOptions) merge(override *ParserOptions) ParserOptions {
+	if override == nil {
+		return o
+	}
+	if override.Separators != "" {
+		o.Separators = override.Separators
+	}
+	if override.RequireSpaceAfterSeparator {
+		o.RequireSpaceAfterSeparator = true
+	}
+	if override.ImplicitFirstBlock {
+		o.ImplicitFirstBlock = true
+	}
+	if override.FuzzyLabelDistance != 0 {
+		o.FuzzyLabelDistance = override.FuzzyLabelDistance
+	}
+	if override.BlockIntroKey != "" {
+		o.BlockIntroKey = override.BlockIntroKey
+	}
+	if override.MaxLineLength != 0 {
+		o.MaxLineLength = override.MaxLineLength
+	}
+	if override.ErrorOnLongLine {
+		o.ErrorOnLongLine = true
+	}
+	if override.DuplicatePolicy != DuplicateCollect {
+		o.DuplicatePolicy = override.DuplicatePolicy
+	}
+	if override.NormalizeUnicodePunctuation {
+		o.NormalizeUnicodePunctuation = true
+	}
+	if override.JSONRepair != nil {
+		o.JSONRepair = override.JSONRepair
+	}
+	if override.EmptyJSONAsNull {
+		o.EmptyJSONAsNull = true
+	}
+	if override.WarnAmbiguousMatches {
+		o.WarnAmbiguousMatches = true
+	}
+	if override.TreatTabAsSeparator {
+		o.TreatTabAsSeparator = true
+	}
+	if override.BlockSeparatorPattern != "" {
+		o.BlockSeparatorPattern = override.BlockSeparatorPattern
+	}
+	if override.DisallowInterleavedFields {
+		o.DisallowInterleavedFields = true
+	}
+	if override.MaxErrors != 0 {
+		o.MaxErrors = override.MaxErrors
+	}
+	if override.StripLabelPrefixRunes != "" {
+		o.StripLabelPrefixRunes = override.StripLabelPrefixRunes
+	}
+	if override.GlobalAliases != nil {
+		o.GlobalAliases = override.GlobalAliases
+	}
+	if override.BlockStartAsKey {
+		o.BlockStartAsKey = true
+	}
+	if override.AgentMode {
+		o.AgentMode = true
+	}
+	if override.FlexibleWordSeparators {
+		o.FlexibleWordSeparators = true
+	}
+	if override.SplitInlineLabels {
+		o.SplitInlineLabels = true
+	}
+	if override.EnforceOrder {
+		o.EnforceOrder = true
+	}
+	if override.SkipPreambleUntilFirstLabel {
+		o.SkipPreambleUntilFirstLabel = true
+	}
+	if override.MaxJSONDepth != 0 {
+		o.MaxJSONDepth = override.MaxJSONDepth
+	}
+	if override.SkipEmptyBlocks {
+		o.SkipEmptyBlocks = true
+	}
+	if override.FallbackSingleBlock {
+		o.FallbackSingleBlock = true
+	}
+	if override.UnwrapOuterFenceOnly {
+		o.UnwrapOuterFenceOnly = true
+	}
+	return o
+}
+
+// Patterns returns the source of the regular expression used to match each
+// label, keyed by original label name, for debugging matching problems.
+// Computed labels have none, and patterns for GlobalAliases are not included.
+func (p *Parser) Patterns() map[string]string {
+	sources := make(map[string]string, len(p.patterns))
+	for _, pat := range p.patterns {
+		name := p.originalNames[pat.Name]
+		if _, ok := sources[name]; !ok {
+			sources[name] = pat.Pattern.String()
+		}
+	}
+	return sources
+}
+
+// buildPatterns constructs regex patterns for each label, followed by one for
+// each applicable GlobalAliases entry (in sorted order) so that declared names
+// win ties. Each pattern captures the label as written (group 1) and the
+// separator run (group 2, or group 3 for a lone tab under TreatTabAsSeparator).
+// originalNames supplies the declared casing for RequireExactCase labels.
+func buildPatterns(labels []Label, originalNames map[string]string, opts ParserOptions) []labelPattern {
 	var patterns []labelPattern
-	escapedSeparators := regexp.QuoteMeta(separators)
-	escapedSeparators = strings.ReplaceAll(escapedSeparators, `\-`, `-`)
-	if strings.Contains(escapedSeparators, "-") {
-		escapedSeparators = strings.ReplaceAll(escapedSeparators, "-", "")
-		escapedSeparators += "-"
+	run := separatorRun(opts)
+	wordSep := `\s+`
+	if opts.FlexibleWordSeparators {
+		wordSep = `[\s_-]+`
+	}
+	build := func(name string, label Label) {
+		words := strings.Fields(name)
+		quoted := make([]string, len(words))
+		for i, word := range words {
+			quoted[i] = regexp.QuoteMeta(word) // Names match literally
+		}
+		labelRegex := strings.Join(quoted, wordSep)
+		if label.NumberedSuffix {
+			labelRegex += `(?:\s*#?\d+)?`
+		}
+		labelRun := run
+		if label.FlagOnly {
+			// Flags may also appear bare, without a separator.
+			labelRun = `(?:` + run + `|\s*$)`
+		}
+		flags := `(?i)`
+		if label.RequireExactCase {
+			flags = ""
+		}
+		pattern := regexp.MustCompile(flags + `^\s*(` + labelRegex + `)` + labelRun)
+		var firstWord string
+		if len(words) > 0 {
+			firstWord = foldString(words[0])
+		}
+		patterns = append(patterns, labelPattern{Name: label.Name, Pattern: pattern, firstWord: firstWord})
 	}
 
+	declared := make(map[string]Label, len(labels))
 	for _, label := range labels {
-		labelRegex := strings.Join(strings.Fields(label.Name), `\s+`)
-		pattern := regexp.MustCompile(`(?i)^\s*` + labelRegex + `\s*[` + escapedSeparators + `]+\s*`)
-		patterns = append(patterns, labelPattern{Name: label.Name, Pattern: pattern})
+		declared[label.Name] = label
+		if label.Computed != "" {
+			continue // Computed labels never match input lines
+		}
+		if label.RequireExactCase {
+			build(originalNames[label.Name], label)
+		} else {
+			build(label.Name, label)
+		}
+	}
+	for _, alias := range slices.Sorted(maps.Keys(opts.GlobalAliases)) {
+		lowerAlias := strings.ToLower(alias)
+		label, ok := declared[strings.ToLower(opts.GlobalAliases[alias])]
+		if _, shadowed := declared[lowerAlias]; !ok || shadowed || label.Computed != "" {
+			continue
+		}
+		build(alias, label)
 	}
 	return patterns
 }
 
+// minIndexedPatterns is the pattern count from which lines are matched through
+// a patternIndex; below it, trying every pattern is cheaper.
+const minIndexedPatterns = 16
+
+// patternIndex narrows the label patterns that can match a line to those
+// whose first word starts the line, so that large schemas avoid running every
+// regex on every line. Patterns are identified by their position in the
+// parser's pattern slice.
+type patternIndex struct {
+	byWord   map[string][]int // Folded first word to pattern positions
+	wordLens []int            // Distinct first-word lengths in runes, ascending
+	always   []int            // Patterns that cannot be indexed
+}
+
+// newPatternIndex indexes patterns by first word. It returns nil for small
+// pattern sets, which are matched without an index.
+func newPatternIndex(patterns []labelPattern) *patternIndex {
+	if len(patterns) < minIndexedPatterns {
+		return nil
+	}
+	idx := &patternIndex{byWord: make(map[string][]int)}
+	for i, pat := range patterns {
+		if pat.firstWord == "" {
+			idx.always = append(idx.always, i)
+			continue
+		}
+		if _, seen := idx.byWord[pat.firstWord]; !seen {
+			idx.wordLens = append(idx.wordLens, utf8.RuneCountInString(pat.firstWord))
+		}
+		idx.byWord[pat.firstWord] = append(idx.byWord[pat.firstWord], i)
+	}
+	slices.Sort(idx.wordLens)
+	idx.wordLens = slices.Compact(idx.wordLens)
+	return idx
+}
+
+// candidates appends to dst, in ascending order, the positions of the patterns
+// that may match line: those whose first word begins the line once leading
+// regex whitespace is skipped, and those that are not indexed.
+func (idx *patternIndex) candidates(line string, dst []int) []int {
+	dst = append(dst, idx.always...)
+	line = strings.TrimLeft(line, "\t\n\f\r ") // The \s class of the patterns
+	var (
+		folded strings.Builder
+		runes  int
+		next   int // Index into wordLens of the next length to look up
+	)
+	for _, r := range line {
+		if next == len(idx.wordLens) {
+			break
+		}
+		folded.WriteRune(foldRune(r))
+		runes++
+		if runes == idx.wordLens[next] {
+			dst = append(dst, idx.byWord[folded.String()]...)
+			next++
+		}
+	}
+	slices.Sort(dst)
+	return dst
+}
+
+// foldString maps every rune of s to foldRune, so that two strings are equal
+// after folding exactly when they match under the (?i) regex flag.
+func foldString(s string) string {
+	return strings.Map(foldRune, s)
+}
+
+// foldRune returns the smallest rune that is equivalent to r under simple
+// case folding.
+func foldRune(r rune) rune {
+	smallest := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		smallest = min(smallest, f)
+	}
+	return smallest
+}
+
 // buildSeparatorRegex creates a regex for separator matching.
-func buildSeparatorRegex(separators string) *regexp.Regexp {
+func buildSeparatorRegex(opts ParserOptions) *regexp.Regexp {
+	return regexp.MustCompile(`^` + separatorRun(opts))
+}
+
+// separatorRun returns the regex fragment matching the separator between a
+// label and its value, including surrounding whitespace. The separator run is
+// captured; under TreatTabAsSeparator a lone tab is captured by a second group.
+func separatorRun(opts ParserOptions) string {
+	run := `\s*([` + separatorClass(opts.Separators) + `]+)` + separatorTail(opts)
+	if !opts.TreatTabAsSeparator {
+		return run
+	}
+	return `(?:` + run + `|[ ]*(\t)\s*)`
+}
+
+// separatorSpan returns the offsets of the separator run in a label match, or
+// -1 when the label matched without one.
+func separatorSpan(loc []int) (int, int) {
+	if loc[4] >= 0 {
+		return loc[4], loc[5]
+	}
+	if len(loc) > 6 && loc[6] >= 0 {
+		return loc[6], loc[7]
+	}
+	return -1, -1
+}
+
+// separatorGroup returns the separator text captured by a match of a pattern
+// built from separatorRun, whose first group is the label or candidate.
+func separatorGroup(line string, loc []int) string {
+	if start, end := separatorSpan(loc); start >= 0 {
+		return line[start:end]
+	}
+	return ""
+}
+
+// separatorClass escapes the separator characters for use inside a regex
+// character class, moving any dash to the end so it is taken literally.
+func separatorClass(separators string) string {
 	escapedSeparators := regexp.QuoteMeta(separators)
 	escapedSeparators = strings.ReplaceAll(escapedSeparators, `\-`, `-`)
 	if strings.Contains(escapedSeparators, "-") {
 		escapedSeparators = strings.ReplaceAll(escapedSeparators, "-", "")
 		escapedSeparators += "-"
 	}
-	return regexp.MustCompile(`^\s*[` + escapedSeparators + `]+`)
+	return escapedSeparators
+}
+
+// separatorTail returns the regex fragment that must follow the separator run.
+// With RequireSpaceAfterSeparator the run must be followed by whitespace or the
+// end of the line; otherwise any (possibly empty) whitespace is consumed.
+func separatorTail(opts ParserOptions) string {
+	if opts.RequireSpaceAfterSeparator {
+		return `(?:\s+|$)`
+	}
+	return `\s*`
+}
+
+// patternsDiffer reports whether switching between two option sets requires
+// recompiling the label patterns.
+func patternsDiffer(a, b ParserOptions) bool {
+	return a.Separators != b.Separators ||
+		a.RequireSpaceAfterSeparator != b.RequireSpaceAfterSeparator ||
+		a.TreatTabAsSeparator != b.TreatTabAsSeparator ||
+		a.FlexibleWordSeparators != b.FlexibleWordSeparators ||
+		!maps.Equal(a.GlobalAliases, b.GlobalAliases)
 }
@@ -1,41 +1,390 @@
 package structuredparse
 
 import (
+	"bytes"
 	"encoding/json"
+	"reflect"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
+// defaultMaxJSONDepth is the nesting limit used when MaxJSONDepth is zero.
+const defaultMaxJSONDepth = 100
+
 var (
 	codeBlockRe  = regexp.MustCompile("(?s)```(?:\\w+)?\\s*(.*?)\\s*```")
 	inlineCodeRe = regexp.MustCompile("`([^`]+)`")
+
+	// computedPlaceholderRe matches a {Name} placeholder in a Computed template.
+	computedPlaceholderRe = regexp.MustCompile(`\{[^{}]+\}`)
+
+	// unitValueRe matches a number followed by an optional single unit token.
+	unitValueRe = regexp.MustCompile(`^([+-]?(?:\d+(?:\.\d*)?|\.\d+)(?:[eE][+-]?\d+)?)\s*(\S*)$`)
+
+	// unicodePunctuationReplacer maps typographic quotes and dashes commonly
+	// produced by models to their ASCII equivalents.
+	unicodePunctuationReplacer = strings.NewReplacer(
+		"\u201c", `"`, "\u201d", `"`, "\u201e", `"`, "\u201f", `"`,
+		"\u2018", "'", "\u2019", "'", "\u201a", "'", "\u201b", "'",
+		"\u2010", "-", "\u2011", "-", "\u2012", "-", "\u2013", "-",
+		"\u2014", "-", "\u2015", "-", "\u2212", "-",
+	)
 )
 
 // Parser parses labeled sections from text input.
+//
+// A Parser is immutable once created (SetSeparators aside) and is safe for
+// concurrent use by multiple goroutines, so a single instance can be shared;
+// there is no need to pool parsers. Per-call scratch buffers are pooled
+// internally.
 type Parser struct {
-	labels        []Label           // Internal copy of labels (with lowercase names)
-	patterns      []labelPattern    // Regex patterns for label matching
-	labelMap      map[string]Label  // Map of lowercase label name -> Label (for lookup)
-	originalNames map[string]string // Map of lowercase label name -> original name (for result keys)
-	separators    string            // Allowed separator characters
-	separatorRe   *regexp.Regexp    // Precompiled regex for separator matching
+	labels        []Label            // Internal copy of labels (with lowercase names)
+	patterns      []labelPattern     // Regex patterns for label matching
+	patternIdx    *patternIndex      // First-word index over patterns (nil for small label sets)
+	labelMap      map[string]Label   // Map of lowercase label name -> Label (for lookup)
+	originalNames map[string]string  // Map of lowercase label name -> original name (for result keys)
+	separators    string             // Allowed separator characters
+	separatorRe   *regexp.Regexp     // Precompiled regex for separator matching
+	candidateRe   *regexp.Regexp     // Precompiled regex capturing a candidate label for fuzzy matching
+	nested        map[string]*Parser // Map of lowercase label name -> sub-parser for its NestedLabels
+	blockParsers  map[string]*Parser // Map of lowercase block start name -> sub-parser limited to its BlockFields
+	blockSepRe    *regexp.Regexp     // Compiled BlockSeparatorPattern, or nil
+	failFast      bool               // Stop processing values at the first error (set by ParseFailFast)
+	opts          ParserOptions      // Resolved options (defaults applied)
 }
 
 // Parse parses the text into a map of label names (preserving original casing) to their values.
 // Each label can have a single value or a slice of values.
 //   - Detects labels using regex patterns (case-insensitive, multiple separators)
 //   - Collects multi-line values for labels
+//   - Treats text inside \{{ ... }} escape fences as plain text, dropping the markers
 //   - Parses JSON fields if specified
 //   - Validates required fields and dependencies
 //   - Returns a map of results and a slice of error strings
 func (p *Parser) Parse(text string) (map[string]interface{}, []string) {
-	return p.parseLines(cleanText(text))
+	results, errs := p.parseLines(p.clean(text))
+	return results, errorStrings(p.limitErrors(errs))
+}
+
+// ParseE parses the text like Parse but returns structured errors, allowing
+// callers to branch on the error kind via the Code field or errors.Is with the
+// exported sentinels (ErrRequired, ErrRequiredWith, ErrJSON).
+func (p *Parser) ParseE(text string) (map[string]interface{}, []*ParseError) {
+	results, errs := p.parseLines(p.clean(text))
+	return results, p.limitErrors(errs)
+}
+
+// ParseWithWarnings parses the text like Parse but returns warnings, such as
+// those for missing Recommended labels, separately from errors. MaxErrors
+// applies to each list on its own.
+func (p *Parser) ParseWithWarnings(text string) (map[string]interface{}, []string, []string) {
+	results, all := p.parseLines(p.clean(text))
+	var errs, warnings []*ParseError
+	for _, e := range all {
+		if e.Warning {
+			warnings = append(warnings, e)
+		} else {
+			errs = append(errs, e)
+		}
+	}
+	return results, errorStrings(p.limitErrors(errs)), errorStrings(p.limitErrors(warnings))
+}
+
+// ParseErr parses the text like Parse but reports the errors as a single
+// error built with JoinErrors, which is nil when there are none.
+func (p *Parser) ParseErr(text string) (map[string]interface{}, error) {
+	results, errs := p.Parse(text)
+	return results, JoinErrors(errs)
+}
+
+// ParseWithFallbacks parses the text with each parser in turn, for models that
+// may emit one of several formats. It returns the result and errors (as from
+// Parse) of the first parser with no errors, or else of the parser with the
+// fewest, along with that parser's index. Warnings are not counted. With no
+// parsers the index is -1.
+func ParseWithFallbacks(text string, parsers ...*Parser) (map[string]interface{}, int, []string) {
+	var (
+		bestResult map[string]interface{}
+		bestErrs   []string
+		bestIndex  = -1
+		bestCount  int
+	)
+	for i, p := range parsers {
+		results, errs := p.parseLines(p.clean(text))
+		count := 0
+		for _, e := range errs {
+			if !e.Warning {
+				count++
+			}
+		}
+		if bestIndex < 0 || count < bestCount {
+			bestResult, bestErrs, bestIndex, bestCount = results, errorStrings(p.limitErrors(errs)), i, count
+		}
+		if count == 0 {
+			break
+		}
+	}
+	return bestResult, bestIndex, bestErrs
+}
+
+// ParseFailFast parses the text like Parse but stops at the first error, such
+// as invalid JSON or a failed required check, returning it along with the
+// partial result built so far. Values are processed in label declaration
+// order, so labels declared after the failing one are left out. Warnings are
+// not errors and never stop parsing. The error is nil when parsing succeeds.
+func (p *Parser) ParseFailFast(text string) (map[string]interface{}, error) {
+	q := *p
+	q.failFast = true
+	results, errs := q.parseLines(p.clean(text))
+	for _, e := range errs {
+		if !e.Warning {
+			return results, e
+		}
+	}
+	return results, nil
+}
+
+// ParseWith parses the text like Parse, but with override applied on top of the
+// parser's options for this call only, including for nested labels and
+// BlockFields. The override is shallow: each non-zero field in override
+// replaces the parser's value, and zero fields keep it, so an override can
+// turn an option on or change its value but never switch it off; keep a
+// separate Parser for that. A nil override is equivalent to Parse.
+//
+// Overriding Separators, RequireSpaceAfterSeparator, TreatTabAsSeparator,
+// FlexibleWordSeparators or GlobalAliases with a different value requires
+// recompiling the label patterns, which happens on every such call; keep a
+// dedicated Parser instead if an alternate separator set is used frequently.
+// All other options are applied without any rebuild. If the overridden
+// Separators appear in a label name, as NewParser and SetSeparators reject,
+// nothing is parsed and that error is returned.
+func (p *Parser) ParseWith(text string, override *ParserOptions) (map[string]interface{}, []string) {
+	q, err := p.withOptions(override)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+	return q.Parse(text)
+}
+
+// withOptions returns a shallow copy of the parser with override applied,
+// or the parser itself when there is nothing to override.
+func (p *Parser) withOptions(override *ParserOptions) (*Parser, error) {
+	if override == nil {
+		return p, nil
+	}
+	q := *p
+	q.opts = p.opts.merge(override)
+	if patternsDiffer(q.opts, p.opts) {
+		if err := checkNameSeparators(q.labels, q.originalNames, q.opts); err != nil {
+			return nil, err
+		}
+		q.separators = q.opts.Separators
+		q.patterns = buildPatterns(q.labels, q.originalNames, q.opts)
+		q.patternIdx = newPatternIndex(q.patterns)
+		q.separatorRe = buildSeparatorRegex(q.opts)
+		q.candidateRe = buildCandidateRegex(q.opts)
+	}
+	var err error
+	if q.nested, err = subParsersWithOptions(p.nested, override); err != nil {
+		return nil, err
+	}
+	if q.blockParsers, err = subParsersWithOptions(p.blockParsers, override); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// subParsersWithOptions applies override to each sub-parser in subs, as
+// withOptions does, returning subs itself when it is empty.
+func subParsersWithOptions(subs map[string]*Parser, override *ParserOptions) (map[string]*Parser, error) {
+	if len(subs) == 0 {
+		return subs, nil
+	}
+	applied := make(map[string]*Parser, len(subs))
+	for name, sub := range subs {
+		q, err := sub.withOptions(override)
+		if err != nil {
+			return nil, err
+		}
+		applied[name] = q
+	}
+	return applied, nil
+}
+
+// ParseWithSpans parses the text like Parse and also returns, for each field
+// that appeared, its {startLine, endLine} span (1-based, inclusive) from the
+// label line to the last non-blank line of its value. For a repeated label the
+// span of the last occurrence is returned. Line numbers refer to the text
+// after markdown code fences are stripped, which equals the input when it has
+// none.
+func (p *Parser) ParseWithSpans(text string) (map[string]interface{}, map[string][2]int, []string) {
+	spans := make(map[string][2]int)
+	results, errs := p.parseLinesWithSpans(p.clean(text), spans)
+	return results, spans, errorStrings(p.limitErrors(errs))
+}
+
+// ParseToOrderedJSON parses the text like Parse and encodes the result as a
+// JSON object whose keys follow label declaration order, for deterministic
+// API responses and snapshot tests. Nested objects use encoding/json's sorted
+// key order.
+func (p *Parser) ParseToOrderedJSON(text string) ([]byte, []string) {
+	results, errList := p.Parse(text)
+	data, err := p.orderedJSON(results)
+	if err != nil {
+		return nil, append(errList, "failed to encode result: "+err.Error())
+	}
+	return data, errList
+}
+
+// orderedJSON encodes a result map with its label keys in declaration order.
+func (p *Parser) orderedJSON(results map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for _, label := range p.labels {
+		name := p.originalNames[label.Name]
+		value, ok := results[name]
+		if !ok {
+			continue
+		}
+		if buf.Len() > 1 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(encoded)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// Marshal renders a parse result back to labeled text, one "Label: value"
+// line per entry in label declaration order, using the parser's first
+// separator. Multi-line strings keep their lines, repeated labels and
+// collected duplicates are written once per entry (a JSON label's list is
+// written as one JSON array), SplitOn lists are joined with their separator,
+// nested results are indented under their label and any other value,
+// including a JSON label's string, is written as compact JSON. A value that
+// starts with a separator character, or whose first line ends in whitespace,
+// begins on the next line. Empty strings, false flags and computed fields are
+// omitted.
+//
+// For a result returned by Parse with p, parsing the output yields the same
+// result, provided no value line looks like a label line of its own, no value
+// holds backticks, which Parse reads as code markup, and the text is valid
+// UTF-8, which encoding/json would otherwise replace.
+func (p *Parser) Marshal(result map[string]interface{}) (string, error) {
+	var b strings.Builder
+	sep := string([]rune(p.separators)[:1])
+	for _, label := range p.labels {
+		name := p.originalNames[label.Name]
+		value, ok := result[name]
+		if !ok || label.Computed != "" {
+			continue
+		}
+		entries := []interface{}{value}
+		if items, isSlice := value.([]interface{}); isSlice && (label.repeats() || !label.IsJSON) {
+			entries = items
+		}
+		for _, entry := range entries {
+			text, err := p.marshalEntry(label, entry)
+			if err != nil {
+				return "", err
+			}
+			if label.FlagOnly && entry != true || !label.FlagOnly && !label.repeats() && text == "" {
+				continue
+			}
+			b.WriteString(name)
+			b.WriteString(sep)
+			switch {
+			case text == "":
+			case p.nested[label.Name] != nil:
+				b.WriteString("\n  ")
+				b.WriteString(strings.ReplaceAll(text, "\n", "\n  "))
+			case strings.ContainsAny(text[:1], p.separators) || firstLineTrimmed(text):
+				// On the label line it would be read as part of the separator
+				// or lose trailing space
+				b.WriteString("\n")
+				b.WriteString(text)
+			default:
+				b.WriteString(" ")
+				b.WriteString(text)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// firstLineTrimmed reports whether the first line of text ends in whitespace,
+// which is trimmed from a value on the label line.
+func firstLineTrimmed(text string) bool {
+	first, _, _ := strings.Cut(text, "\n")
+	return strings.TrimRightFunc(first, unicode.IsSpace) != first
+}
+
+// marshalEntry renders a single entry of label for Marshal.
+func (p *Parser) marshalEntry(label Label, entry interface{}) (string, error) {
+	if sub := p.nested[label.Name]; sub != nil {
+		if nested, ok := entry.(map[string]interface{}); ok {
+			return sub.Marshal(nested)
+		}
+	}
+	switch v := entry.(type) {
+	case string:
+		if !label.IsJSON || v == "" {
+			return v, nil
+		}
+	case bool:
+		if label.FlagOnly {
+			return "", nil
+		}
+	case []string:
+		if label.ParagraphsAsArray {
+			return strings.Join(v, "\n\n"), nil
+		}
+		if label.SplitOn != "" && len(v) == 0 {
+			return label.SplitOn, nil // A bare separator parses as an empty list
+		}
+		if label.SplitOn != "" {
+			return strings.Join(v, label.SplitOn+" "), nil
+		}
+	}
+	data, err := json.Marshal(entry)
+	return string(data), err
 }
 
 // parseLines parses already-cleaned text that has been split into lines.
 // This is used internally to avoid double-cleaning in ParseBlocks.
-func (p *Parser) parseLines(text string) (map[string]interface{}, []string) {
+func (p *Parser) parseLines(text string) (map[string]interface{}, []*ParseError) {
+	return p.parseLinesAt(text, 1, nil)
+}
+
+// parseLinesWithSpans implements parseLines, recording field spans keyed by
+// original label name into spans when it is non-nil.
+func (p *Parser) parseLinesWithSpans(text string, spans map[string][2]int) (map[string]interface{}, []*ParseError) {
+	return p.parseLinesAt(text, 1, spans)
+}
+
+// parseLinesAt implements parseLines for text whose first line is line
+// firstLine of the input, so that line numbers in spans and messages refer to
+// the whole input when parsing a single block.
+func (p *Parser) parseLinesAt(text string, firstLine int, spans map[string][2]int) (map[string]interface{}, []*ParseError) {
 	lines := splitAndTrimLines(text)
+	lineErrs := p.guardLineLength(lines)
+	p.blankPreamble(lines)
 
 	data := make(map[string][]string)
 	for _, label := range p.labels {
@@ -43,39 +392,311 @@ func (p *Parser) parseLines(text string) (map[string]interface{}, []string) {
 	}
 	var (
 		currentLabel string
-		currentEntry strings.Builder
+		currentEntry = getEntryBuffer()
+		// present records every label that appeared, even with an empty value.
+		present = make(map[string]bool)
+		// inFence is true inside a \{{ ... }} escape fence, where label
+		// detection is disabled.
+		inFence bool
+		// awaitValue is true after a ValueOnNextLine label line without a
+		// value, until the next non-blank line.
+		awaitValue bool
+		// lastLabel is the most recently started label, for detecting
+		// interleaved fields.
+		lastLabel string
+		// order lists labels in the order they first appeared.
+		order []string
+		// jsonEnd is the last line of an IsJSON value whose brackets span
+		// several lines, or -1; lines up to it never start a label, as in
+		// blockSplitter. A value that never closes does not suppress labels.
+		// jsonScanned is the last line the search for jsonEnd looked at, so
+		// that a continuation line only starts a new search past it.
+		jsonEnd, jsonScanned = -1, -1
 	)
+	// extendSpan moves the current field's span end to line i when the line
+	// contributes content.
+	extendSpan := func(i int, line string) {
+		lineNum := i + firstLine
+		if spans != nil && currentLabel != "" && strings.TrimSpace(line) != "" {
+			name := p.originalNames[currentLabel]
+			spans[name] = [2]int{spans[name][0], lineNum}
+		}
+	}
 
-	for _, line := range lines {
-		labelName, value := p.parseLine(line)
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		lineNum := i + firstLine
+		if inFence {
+			var text string
+			text, inFence = stripFences(line, true)
+			if currentLabel != "" {
+				currentEntry.WriteString("\n")
+				currentEntry.WriteString(text)
+				extendSpan(i, text)
+			}
+			continue
+		}
+		if currentLabel != "" && p.labelMap[currentLabel].StopAtBlankLine {
+			// Blank-line-bounded fields take every line verbatim, including
+			// label-looking ones, until the first blank line.
+			if line == "" {
+				p.finalizeEntry(data, currentLabel, currentEntry.String())
+				currentEntry.Reset()
+				currentLabel = ""
+			} else {
+				if currentEntry.Len() > 0 {
+					currentEntry.WriteString("\n")
+				}
+				currentEntry.WriteString(line)
+				extendSpan(i, line)
+			}
+			continue
+		}
+		if i <= jsonEnd {
+			currentEntry.WriteString("\n")
+			line, inFence = stripFences(line, false)
+			currentEntry.WriteString(line)
+			extendSpan(i, line)
+			continue
+		}
+		labelName, value := "", ""
+		forceValue := awaitValue && line != ""
+		if forceValue {
+			awaitValue = false
+		}
+		nestedLine := p.isNestedLine(currentLabel, line)
+		if !nestedLine && !forceValue {
+			labelName, value = p.parseLine(line)
+		}
+		// rest is the remainder of a line holding several inline labels; it is
+		// processed again as if it were the next line.
+		rest := ""
+		if labelName != "" && p.opts.SplitInlineLabels {
+			value, rest = p.splitInlineLabel(labelName, value)
+		}
+		if labelName != "" && p.opts.WarnAmbiguousMatches {
+			if warning := p.ambiguityWarning(lineNum, line); warning != nil {
+				lineErrs = append(lineErrs, warning)
+			}
+		}
 		if labelName != "" {
 			// If we were collecting a previous entry, finalize it
 			if currentLabel != "" {
-				finalizeEntry(data, currentLabel, currentEntry.String())
+				p.finalizeEntry(data, currentLabel, currentEntry.String())
 				currentEntry.Reset()
 			}
 			currentLabel = strings.ToLower(labelName)
+			if p.opts.DisallowInterleavedFields && present[currentLabel] && currentLabel != lastLabel && !p.labelMap[currentLabel].repeats() {
+				name := p.originalNames[currentLabel]
+				lineErrs = append(lineErrs, &ParseError{
+					Code:    CodeInterleavedField,
+					Label:   name,
+					Message: "'" + name + "' reappears on line " + strconv.Itoa(lineNum) + " after another field",
+				})
+			}
+			lastLabel = currentLabel
+			if !present[currentLabel] {
+				order = append(order, currentLabel)
+			}
+			present[currentLabel] = true
+			if p.labelMap[currentLabel].IncludeLabelInValue {
+				value = line
+			}
+			value, inFence = stripFences(value, false)
 			currentEntry.WriteString(value)
+			if p.labelMap[currentLabel].IsJSON {
+				jsonEnd, jsonScanned = closingJSONLine(lines, i, value)
+			}
+			awaitValue = value == "" && p.labelMap[currentLabel].ValueOnNextLine
+			if spans != nil {
+				spans[p.originalNames[currentLabel]] = [2]int{lineNum, lineNum}
+			}
 		} else if currentLabel != "" {
-			isLabelLine := p.isLabelLine(line)
-			if !isLabelLine {
-				if currentEntry.Len() > 0 {
-					currentEntry.WriteString("\n")
-				}
-				currentEntry.WriteString(line)
+			// parseLine has already tried every label pattern, so anything it
+			// did not match is a continuation line.
+			if currentEntry.Len() > 0 {
+				currentEntry.WriteString("\n")
 			}
+			if p.labelMap[currentLabel].IsJSON && i > jsonScanned {
+				// The value may open on a line after its label
+				jsonEnd, jsonScanned = closingJSONLine(lines, i, line)
+			}
+			line, inFence = stripFences(line, false)
+			currentEntry.WriteString(line)
+			extendSpan(i, line)
+		} else {
+			_, inFence = stripFences(line, false)
+		}
+		if rest != "" {
+			lines[i] = rest
+			i--
 		}
 	}
 	if currentLabel != "" {
-		finalizeEntry(data, currentLabel, currentEntry.String())
+		p.finalizeEntry(data, currentLabel, currentEntry.String())
 	}
+	putEntryBuffer(currentEntry)
 
-	results, errList := p.processResults(data)
-	return results, errList
+	results, errList := p.processResults(data, present, order)
+	return results, append(lineErrs, errList...)
+}
+
+// closingJSONLine returns the index of the line after start at which a JSON
+// value beginning with value closes its brackets, or -1 if it is closed on
+// its first line or never closes, along with the last line it examined.
+func closingJSONLine(lines []string, start int, value string) (int, int) {
+	var balance jsonBalance
+	balance.feed(value)
+	if !balance.open() {
+		return -1, start
+	}
+	for j := start + 1; j < len(lines); j++ {
+		if balance.feed(lines[j]); !balance.open() {
+			return j, j
+		}
+	}
+	return -1, len(lines) - 1
+}
+
+// Escape fence markers. Text between them is never treated as a label.
+const (
+	fenceOpen  = `\{{`
+	fenceClose = "}}"
+)
+
+// stripFences removes escape fence markers from s, given whether s starts
+// inside a fence, and reports whether a fence is still open at its end.
+// Closing markers outside a fence are ordinary text.
+func stripFences(s string, inFence bool) (string, bool) {
+	if !inFence && !strings.Contains(s, fenceOpen) {
+		return s, false
+	}
+	var b strings.Builder
+	for s != "" {
+		marker := fenceOpen
+		if inFence {
+			marker = fenceClose
+		}
+		idx := strings.Index(s, marker)
+		if idx < 0 {
+			b.WriteString(s)
+			break
+		}
+		b.WriteString(s[:idx])
+		s = s[idx+len(marker):]
+		inFence = !inFence
+	}
+	return b.String(), inFence
+}
+
+// entryBufferPool recycles the scratch buffers parseLines uses to accumulate
+// values, reducing allocations when many inputs are parsed. Buffers that grew
+// past maxPooledEntryBuffer are dropped rather than kept alive.
+var entryBufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+const maxPooledEntryBuffer = 64 << 10
+
+// getEntryBuffer returns an empty scratch buffer from the pool.
+func getEntryBuffer() *bytes.Buffer {
+	buf := entryBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putEntryBuffer returns a scratch buffer to the pool.
+func putEntryBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledEntryBuffer {
+		entryBufferPool.Put(buf)
+	}
 }
 
 // cleanText removes markdown code blocks and inline code from the input text.
+// Line endings are normalized first, so no carriage return reaches a value.
 func cleanText(text string) string {
+	return strings.TrimSpace(stripCodeMarkup(normalizeNewlines(text)))
+}
+
+// clean is cleanText under the parser's options.
+func (p *Parser) clean(text string) string {
+	return strings.TrimSpace(p.stripMarkup(normalizeNewlines(text)))
+}
+
+// stripMarkup removes code markup from text, either all of it or only an
+// outer fence under UnwrapOuterFenceOnly.
+func (p *Parser) stripMarkup(text string) string {
+	if p.opts.UnwrapOuterFenceOnly {
+		return unwrapOuterFence(text)
+	}
+	return stripCodeMarkup(text)
+}
+
+// unwrapOuterFence returns the content of a markdown fence enclosing all of
+// text, or text unchanged if there is none. Inside it, a line ending in a
+// fence with a language tag or after other text ("```json", "Args: ```")
+// opens an inner fence and a bare "```" line closes one; a bare "```" with
+// no inner fence open closes the outer fence early, so the text holds
+// several fences and is left as it is.
+func unwrapOuterFence(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	last := len(lines) - 1
+	if last < 1 || fenceKind(lines[0]) == noMarkdownFence || fenceKind(lines[last]) != bareMarkdownFence {
+		return text
+	}
+	if strings.Contains(lines[0], "```") && !strings.HasPrefix(strings.TrimSpace(lines[0]), "```") {
+		return text // The first line has content before its fence
+	}
+	depth := 0
+	for _, line := range lines[1:last] {
+		switch fenceKind(line) {
+		case openMarkdownFence:
+			depth++
+		case bareMarkdownFence:
+			if depth--; depth < 0 {
+				return text
+			}
+		}
+	}
+	return strings.Join(lines[1:last], "\n")
+}
+
+// Kinds of fence found at the end of a line by fenceKind.
+const (
+	noMarkdownFence   = iota // No unmatched fence
+	bareMarkdownFence        // The line is only "```"
+	openMarkdownFence        // The line ends in a fence with a tag or after other text
+)
+
+// fenceKind classifies the unmatched markdown fence, if any, that ends line.
+// Lines whose fences pair up ("use ```x``` here") have none.
+func fenceKind(line string) int {
+	trimmed := strings.TrimSpace(line)
+	if strings.Count(trimmed, "```")%2 == 0 {
+		return noMarkdownFence
+	}
+	idx := strings.LastIndex(trimmed, "```")
+	tag := trimmed[idx+3:]
+	if strings.ContainsAny(tag, " \t`") {
+		return noMarkdownFence
+	}
+	if idx == 0 && tag == "" {
+		return bareMarkdownFence
+	}
+	return openMarkdownFence
+}
+
+// normalizeNewlines converts CRLF and lone CR line endings to LF.
+func normalizeNewlines(text string) string {
+	if !strings.Contains(text, "\r") {
+		return text
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n")
+}
+
+// stripCodeMarkup replaces markdown code fences and inline code spans with
+// their content.
+func stripCodeMarkup(text string) string {
 	text = codeBlockRe.ReplaceAllStringFunc(text, func(match string) string {
 		sub := codeBlockRe.FindStringSubmatch(match)
 		if len(sub) > 1 {
@@ -83,23 +704,45 @@ func cleanText(text string) string {
 		}
 		return ""
 	})
-	text = inlineCodeRe.ReplaceAllString(text, "$1")
-	return strings.TrimSpace(text)
+	return inlineCodeRe.ReplaceAllString(text, "$1")
 }
 
-// isLabelLine checks if a line starts with a known label.
-func (p *Parser) isLabelLine(line string) bool {
-	trimmed := strings.ToLower(strings.TrimSpace(line))
-	for _, lbl := range p.labels {
-		lowerName := strings.ToLower(lbl.Name)
-		if strings.HasPrefix(trimmed, lowerName) {
-			remain := trimmed[len(lowerName):]
-			if p.separatorRe.MatchString(remain) {
-				return true
-			}
+// ambiguityWarning returns a warning if more than one label pattern matches the
+// line, naming the competing labels and the one that won (see matchPattern).
+func (p *Parser) ambiguityWarning(lineNum int, line string) *ParseError {
+	var (
+		matched []string
+		buf     [minIndexedPatterns]int
+	)
+	line = p.stripLabelPrefix(line)
+	for _, i := range p.candidatePatterns(line, buf[:0]) {
+		if pat := p.patterns[i]; pat.Pattern.MatchString(line) {
+			matched = append(matched, "'"+p.originalNames[pat.Name]+"'")
 		}
 	}
-	return false
+	if len(matched) < 2 {
+		return nil
+	}
+	winner, _ := p.matchPattern(line)
+	name := p.originalNames[winner.Name]
+	return &ParseError{
+		Code:    CodeAmbiguousMatch,
+		Label:   name,
+		Message: "warning: line " + strconv.Itoa(lineNum) + " matches multiple labels (" + strings.Join(matched, ", ") + "); using '" + name + "'",
+		Warning: true,
+	}
+}
+
+// isNestedLine reports whether line is an indented line naming one of the
+// nested labels of currentLabel. Such lines belong to the parent's value even
+// if they would also match a top-level label.
+func (p *Parser) isNestedLine(currentLabel, line string) bool {
+	sub := p.nested[currentLabel]
+	if sub == nil || line == "" || (line[0] != ' ' && line[0] != '\t') {
+		return false
+	}
+	labelName, _ := sub.parseLine(line)
+	return labelName != ""
 }
 
 // splitAndTrimLines splits text into lines and trims right whitespace.
@@ -111,31 +754,185 @@ func splitAndTrimLines(text string) []string {
 	return lines
 }
 
-// parseLine tries to match a label at the start of the line.
+// blankPreamble empties, in place, the lines before the first label line
+// when SkipPreambleUntilFirstLabel is set. Lines are blanked rather than
+// removed so that line numbers stay stable.
+func (p *Parser) blankPreamble(lines []string) {
+	if !p.opts.SkipPreambleUntilFirstLabel {
+		return
+	}
+	for i, line := range lines {
+		if labelName, _ := p.parseLine(line); labelName != "" {
+			return
+		}
+		lines[i] = ""
+	}
+}
+
+// guardLineLength truncates, in place, any line longer than MaxLineLength bytes
+// (at a UTF-8 boundary) so that degenerate inputs do not make label matching
+// expensive. With ErrorOnLongLine set, an error is recorded for each such line.
+func (p *Parser) guardLineLength(lines []string) []*ParseError {
+	if p.opts.MaxLineLength <= 0 {
+		return nil
+	}
+	var errList []*ParseError
+	for i, line := range lines {
+		var lineErr *ParseError
+		if lines[i], lineErr = p.guardLine(i, line); lineErr != nil {
+			errList = append(errList, lineErr)
+		}
+	}
+	return errList
+}
+
+// guardLine applies MaxLineLength to the line at 0-based index idx, returning
+// the possibly truncated line and, under ErrorOnLongLine, an error if it was
+// truncated.
+func (p *Parser) guardLine(idx int, line string) (string, *ParseError) {
+	limit := p.opts.MaxLineLength
+	if limit <= 0 || len(line) <= limit {
+		return line, nil
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+	if !p.opts.ErrorOnLongLine {
+		return line[:cut], nil
+	}
+	return line[:cut], &ParseError{
+		Code:    CodeLineTooLong,
+		Message: "line " + strconv.Itoa(idx+1) + " exceeds maximum length of " + strconv.Itoa(limit),
+	}
+}
+
+// parseLine tries to match a label at the start of the line. Whitespace is
+// allowed between the label and its separator ("Action : run"), and a
+// separator repeated after whitespace ("Action: : run") is dropped.
 func (p *Parser) parseLine(line string) (string, string) {
-	for _, pat := range p.patterns {
-		if loc := pat.Pattern.FindStringIndex(line); loc != nil {
-			value := strings.TrimSpace(line[loc[1]:])
-			return pat.Name, value
+	line = p.stripLabelPrefix(line)
+	if pat, loc := p.matchPattern(line); loc != nil {
+		value := strings.TrimSpace(line[loc[1]:])
+		if value != "" && !p.labelMap[pat.Name].Opaque && strings.ContainsRune(p.separators, []rune(value)[0]) {
+			value = trimRepeatedSeparator(separatorGroup(line, loc), value)
 		}
+		return pat.Name, value
 	}
-	for labelName := range p.labelMap {
-		trimmed := strings.TrimSpace(line)
-		if strings.HasPrefix(strings.ToLower(trimmed), labelName) {
-			remain := trimmed[len(labelName):]
-			if p.separatorRe.MatchString(remain) {
-				content := p.separatorRe.ReplaceAllString(remain, "")
-				return labelName, strings.TrimSpace(content)
-			}
-			return "", trimmed
+	trimmed := strings.TrimSpace(line)
+	lowerTrimmed := strings.ToLower(trimmed)
+	for _, labelDef := range p.labels {
+		labelName := labelDef.Name
+		if labelDef.Computed != "" || labelDef.RequireExactCase || !strings.HasPrefix(lowerTrimmed, labelName) {
+			continue
+		}
+		if loc := p.separatorRe.FindStringIndex(trimmed[len(labelName):]); loc != nil {
+			// Only the label's own separator run is consumed; separator
+			// characters later in the value (e.g. "- bullet") are kept.
+			return labelName, strings.TrimSpace(trimmed[len(labelName)+loc[1]:])
+		}
+	}
+	if p.opts.FuzzyLabelDistance > 0 {
+		if labelName, value, ok := p.fuzzyMatch(line); ok {
+			return labelName, value
 		}
 	}
 	return "", ""
 }
 
+// splitInlineLabel splits the value of a labelName line at the first declared
+// label that follows whitespace, for SplitInlineLabels. It returns the trimmed
+// value before it and the rest of the line from that label on, or the value
+// unchanged and "" if there is nothing to split.
+func (p *Parser) splitInlineLabel(labelName, value string) (string, string) {
+	labelDef := p.labelMap[labelName]
+	if labelDef.IsJSON || labelDef.Opaque || labelDef.IncludeLabelInValue {
+		return value, ""
+	}
+	scan := value
+	if idx := strings.Index(scan, `\{{`); idx >= 0 {
+		scan = scan[:idx]
+	}
+	for j := 1; j < len(scan); j++ {
+		if scan[j-1] != ' ' && scan[j-1] != '\t' || scan[j] == ' ' || scan[j] == '\t' {
+			continue
+		}
+		if _, loc := p.matchPattern(scan[j:]); loc != nil {
+			return strings.TrimSpace(value[:j]), value[j:]
+		}
+	}
+	return value, ""
+}
+
+// matchPattern returns the label pattern matching line and its submatch
+// indices, or nil indices if none matches. When several match, as "Action"
+// and "Action-Input" both do on "Action-Input: x" since a dash is also a
+// separator, the longest label text wins, then the first declared.
+func (p *Parser) matchPattern(line string) (labelPattern, []int) {
+	var (
+		best    labelPattern
+		bestLoc []int
+		buf     [minIndexedPatterns]int
+	)
+	for _, i := range p.candidatePatterns(line, buf[:0]) {
+		pat := p.patterns[i]
+		loc := pat.Pattern.FindStringSubmatchIndex(line)
+		if loc != nil && (bestLoc == nil || loc[3]-loc[2] > bestLoc[3]-bestLoc[2]) {
+			best, bestLoc = pat, loc
+		}
+	}
+	return best, bestLoc
+}
+
+// candidatePatterns appends to dst, in declaration order, the positions of
+// the patterns that may match line, narrowed through the pattern index when
+// the parser has one.
+func (p *Parser) candidatePatterns(line string, dst []int) []int {
+	if p.patternIdx != nil {
+		return p.patternIdx.candidates(line, dst)
+	}
+	for i := range p.patterns {
+		dst = append(dst, i)
+	}
+	return dst
+}
+
+// trimRepeatedSeparator drops a leading copy of sep from value when it stands
+// alone (followed by whitespace or nothing), as in "Action: : run". Other
+// leading separator characters are kept, and so is a lone dash, which reads
+// as a list bullet ("Note - - item").
+func trimRepeatedSeparator(sep, value string) string {
+	if sep == "" || sep == "-" || !strings.HasPrefix(value, sep) {
+		return value
+	}
+	rest := value[len(sep):]
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return value
+	}
+	return strings.TrimSpace(rest)
+}
+
+// stripLabelPrefix removes leading whitespace and StripLabelPrefixRunes from
+// line, leaving it unchanged when the option is unset.
+func (p *Parser) stripLabelPrefix(line string) string {
+	set := p.opts.StripLabelPrefixRunes
+	if set == "" {
+		return line
+	}
+	return strings.TrimLeftFunc(line, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(set, r)
+	})
+}
+
 // finalizeEntry appends a non-empty entry to the data map for a label.
-func finalizeEntry(data map[string][]string, labelName, entry string) {
+// Entries are trimmed of surrounding whitespace, except that labels with Dedent
+// or PreserveIndent keep the leading indentation of their first line, to be
+// dedented later or kept as-is.
+func (p *Parser) finalizeEntry(data map[string][]string, labelName, entry string) {
 	content := strings.TrimSpace(entry)
+	if labelDef := p.labelMap[labelName]; content != "" && (labelDef.Dedent || labelDef.PreserveIndent) && !labelDef.Opaque {
+		content = strings.TrimRight(trimLeadingBlankLines(entry), " \t\r\n")
+	}
 	if content != "" {
 		data[labelName] = append(data[labelName], content)
 	}
@@ -143,35 +940,140 @@ func finalizeEntry(data map[string][]string, labelName, entry string) {
 
 // processResults parses JSON fields, flattens single-value lists, and collects errors.
 // Result map keys use original label names (preserving user's casing).
-func (p *Parser) processResults(rawData map[string][]string) (map[string]interface{}, []string) {
+// Labels listed in present appeared in the input even if they collected no
+// content; order lists them by first appearance.
+func (p *Parser) processResults(rawData map[string][]string, present map[string]bool, order []string) (map[string]interface{}, []*ParseError) {
 	results := make(map[string]interface{})
-	errList := []string{}
-	for lowerName, entries := range rawData {
+	parsed := make(map[string][]interface{})
+	errList := []*ParseError{}
+	for _, label := range p.labels {
+		if p.failFast && hasError(errList) {
+			return results, errList
+		}
+		lowerName := label.Name
+		entries := rawData[lowerName]
 		originalName := p.originalNames[lowerName]
 		if originalName == "" {
 			originalName = lowerName
 		}
 
 		labelDef := p.labelMap[lowerName]
+		if labelDef.Computed != "" {
+			continue // Evaluated once every other field is known
+		}
+		if labelDef.FlagOnly {
+			results[originalName] = present[lowerName]
+			continue
+		}
 		parsedEntries := []interface{}{}
+		// targetSources maps the index of each JSONTarget entry to the generic
+		// value it was decoded from, which dotted RequiredWith paths inspect.
+		var targetSources map[int]interface{}
 		for _, entry := range entries {
-			if labelDef.IsJSON {
+			if labelDef.Opaque {
+				parsedEntries = append(parsedEntries, entry)
+			} else if sub := p.nested[lowerName]; sub != nil {
+				nestedResult, nestedErrs := sub.parseLines(entry)
+				parsedEntries = append(parsedEntries, nestedResult)
+				errList = append(errList, nestedErrs...)
+			} else if labelDef.IsJSON {
 				if strings.TrimSpace(entry) == "" {
 					parsedEntries = append(parsedEntries, map[string]interface{}{})
 					continue
 				}
-				var obj interface{}
-				if err := json.Unmarshal([]byte(entry), &obj); err != nil {
+				obj, data, jsonErr := p.parseJSONEntry(originalName, entry)
+				if jsonErr != nil {
 					parsedEntries = append(parsedEntries, entry)
-					errList = append(errList, "JSON error in '"+originalName+"': "+err.Error())
+					errList = append(errList, jsonErr)
+				} else if labelDef.JSONTarget != nil {
+					typed, targetErr := decodeJSONTarget(originalName, labelDef, obj, data)
+					if targetSources == nil {
+						targetSources = make(map[int]interface{})
+					}
+					targetSources[len(parsedEntries)] = obj
+					parsedEntries = append(parsedEntries, typed)
+					if targetErr != nil {
+						errList = append(errList, targetErr)
+					}
 				} else {
 					parsedEntries = append(parsedEntries, obj)
 				}
+			} else if labelDef.ParseUnit {
+				value, warning := parseUnitEntry(originalName, p.processText(labelDef, entry))
+				parsedEntries = append(parsedEntries, value)
+				if warning != nil {
+					errList = append(errList, warning)
+				}
+			} else if labelDef.ParagraphsAsArray {
+				parsedEntries = append(parsedEntries, splitParagraphs(p.processText(labelDef, entry)))
+			} else if labelDef.SplitOn != "" {
+				items := splitItems(p.processText(labelDef, entry), labelDef.SplitOn)
+				for i := range items {
+					// Title case again per item: "red,green" has a single word.
+					items[i] = normalizeCase(labelDef.NormalizeCase, items[i])
+				}
+				for i := 0; i < len(items) && len(labelDef.Enum) > 0; i++ {
+					var enumErr *ParseError
+					if items[i], enumErr = matchEnum(originalName, labelDef, items[i]); enumErr != nil {
+						errList = append(errList, enumErr)
+					}
+				}
+				parsedEntries = append(parsedEntries, items)
 			} else {
-				parsedEntries = append(parsedEntries, entry)
+				text := p.processText(labelDef, entry)
+				if len(labelDef.Enum) > 0 {
+					var enumErr *ParseError
+					if text, enumErr = matchEnum(originalName, labelDef, text); enumErr != nil {
+						errList = append(errList, enumErr)
+					}
+				}
+				parsedEntries = append(parsedEntries, text)
+			}
+		}
+		if len(entries) == 0 && present[lowerName] && labelDef.IsJSON && !labelDef.Opaque && p.nested[lowerName] == nil {
+			// A JSON label that appeared without a value
+			if p.opts.EmptyJSONAsNull {
+				parsedEntries = append(parsedEntries, nil)
+			} else {
+				parsedEntries = append(parsedEntries, map[string]interface{}{})
 			}
 		}
-		if len(parsedEntries) == 1 {
+		pathEntries := parsedEntries
+		if targetSources != nil {
+			pathEntries = slices.Clone(parsedEntries)
+			for i, obj := range targetSources {
+				pathEntries[i] = obj
+			}
+		}
+		if labelDef.DedupeValues {
+			parsedEntries = dedupeValues(parsedEntries)
+			pathEntries = dedupeValues(pathEntries)
+		}
+		if len(parsedEntries) > 1 && !labelDef.repeats() && p.opts.DuplicatePolicy == DuplicateCollect {
+			errList = append(errList, &ParseError{
+				Code:    CodeNotRepeatable,
+				Label:   originalName,
+				Message: "warning: '" + originalName + "' appears multiple times but is not repeatable; keeping every value",
+				Warning: true,
+			})
+		} else if len(parsedEntries) > 1 && !labelDef.repeats() {
+			errList = append(errList, &ParseError{
+				Code:    CodeNotRepeatable,
+				Label:   originalName,
+				Message: "'" + originalName + "' appears multiple times but is not repeatable",
+			})
+			if p.opts.DuplicatePolicy == DuplicateKeepLast {
+				parsedEntries = parsedEntries[len(parsedEntries)-1:]
+				pathEntries = pathEntries[len(pathEntries)-1:]
+			} else {
+				parsedEntries = parsedEntries[:1]
+				pathEntries = pathEntries[:1]
+			}
+		}
+		parsed[lowerName] = pathEntries
+		if labelDef.repeats() {
+			results[originalName] = parsedEntries
+		} else if len(parsedEntries) == 1 {
 			if str, ok := parsedEntries[0].(string); ok && str == "" {
 				results[originalName] = ""
 			} else {
@@ -183,6 +1085,313 @@ func (p *Parser) processResults(rawData map[string][]string) (map[string]interfa
 			results[originalName] = parsedEntries
 		}
 	}
-	errList = append(errList, p.validateDependencies(rawData)...)
+	if p.failFast && hasError(errList) {
+		return results, errList
+	}
+	for _, label := range p.labels {
+		if label.Computed != "" {
+			results[p.originalNames[label.Name]] = p.computeField(label.Computed, results)
+		}
+	}
+	errList = append(errList, p.validateDependencies(rawData, parsed, present, order)...)
 	return results, errList
 }
+
+// computeField expands a Computed template against the collected results.
+// Non-string values are rendered as compact JSON.
+func (p *Parser) computeField(template string, results map[string]interface{}) string {
+	return computedPlaceholderRe.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := p.originalNames[strings.ToLower(strings.TrimSpace(placeholder[1:len(placeholder)-1]))]
+		switch value := results[name].(type) {
+		case nil:
+			return ""
+		case string:
+			return value
+		default:
+			data, err := json.Marshal(value)
+			if err != nil {
+				return ""
+			}
+			return string(data)
+		}
+	})
+}
+
+// dedupeValues removes entries equal (per ResultsEqual) to an earlier entry,
+// preserving order.
+func dedupeValues(entries []interface{}) []interface{} {
+	unique := entries[:0:0]
+	for _, entry := range entries {
+		seen := false
+		for _, kept := range unique {
+			if ResultsEqual(entry, kept) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			unique = append(unique, entry)
+		}
+	}
+	return unique
+}
+
+// hasError reports whether errs contains anything other than warnings.
+func hasError(errs []*ParseError) bool {
+	for _, e := range errs {
+		if !e.Warning {
+			return true
+		}
+	}
+	return false
+}
+
+// processText applies per-label text transformations to a non-JSON entry.
+func (p *Parser) processText(labelDef Label, entry string) string {
+	if labelDef.Dedent {
+		entry = dedent(entry)
+	}
+	entry = normalizeCase(labelDef.NormalizeCase, entry)
+	if labelDef.TrimTrailing != "" {
+		entry = strings.TrimRightFunc(entry, unicode.IsSpace)
+		entry = strings.TrimRightFunc(strings.TrimRight(entry, labelDef.TrimTrailing), unicode.IsSpace)
+	}
+	return entry
+}
+
+// normalizeCase applies a NormalizeCase mode to s.
+func normalizeCase(mode, s string) string {
+	switch mode {
+	case "upper":
+		return strings.ToUpper(s)
+	case "lower":
+		return strings.ToLower(s)
+	case "title":
+		return titleCase(s)
+	}
+	return s
+}
+
+// titleCase lowercases s and capitalizes the first letter of each word.
+func titleCase(s string) string {
+	runes := []rune(strings.ToLower(s))
+	for i, r := range runes {
+		if i == 0 || unicode.IsSpace(runes[i-1]) {
+			runes[i] = unicode.ToUpper(r)
+		}
+	}
+	return string(runes)
+}
+
+// matchEnum checks a value against the label's Enum. A match returns the
+// value, or the declared option when NormalizeCase is empty; otherwise the
+// value is returned unchanged with an error.
+func matchEnum(originalName string, labelDef Label, value string) (string, *ParseError) {
+	for _, option := range labelDef.Enum {
+		if strings.EqualFold(option, value) {
+			if labelDef.NormalizeCase == "" {
+				return option, nil
+			}
+			return value, nil
+		}
+	}
+	return value, &ParseError{
+		Code:    CodeEnum,
+		Label:   originalName,
+		Message: "'" + originalName + "' must be one of '" + strings.Join(labelDef.Enum, "', '") + "', got '" + value + "'",
+	}
+}
+
+// parseUnitEntry splits a ParseUnit value into its number and unit. If the
+// value is not a number with at most one unit token, it is returned unchanged
+// along with a warning.
+func parseUnitEntry(originalName, entry string) (interface{}, *ParseError) {
+	trimmed := strings.TrimSpace(entry)
+	if m := unitValueRe.FindStringSubmatch(trimmed); m != nil {
+		if number, err := strconv.ParseFloat(m[1], 64); err == nil {
+			return map[string]interface{}{"value": number, "unit": m[2]}, nil
+		}
+	}
+	return entry, &ParseError{
+		Code:    CodeUnitParse,
+		Label:   originalName,
+		Message: "warning: '" + originalName + "' is not a number with a unit; keeping the raw value",
+		Warning: true,
+	}
+}
+
+// splitParagraphs splits text at runs of blank lines into trimmed paragraphs.
+func splitParagraphs(text string) []string {
+	paragraphs := []string{}
+	var current []string
+	flush := func() {
+		if len(current) > 0 {
+			paragraphs = append(paragraphs, strings.TrimSpace(strings.Join(current, "\n")))
+			current = nil
+		}
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		current = append(current, line)
+	}
+	flush()
+	return paragraphs
+}
+
+// splitItems splits text on sep into trimmed, non-empty elements.
+func splitItems(text, sep string) []string {
+	items := []string{}
+	for _, item := range strings.Split(text, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// trimLeadingBlankLines removes whitespace-only lines from the start of text,
+// keeping the indentation of the first non-blank line.
+func trimLeadingBlankLines(text string) string {
+	for {
+		idx := strings.IndexByte(text, '\n')
+		if idx < 0 || strings.TrimSpace(text[:idx]) != "" {
+			return text
+		}
+		text = text[idx+1:]
+	}
+}
+
+// dedent removes the longest common leading whitespace from every line of text,
+// like Python's textwrap.dedent. Whitespace-only lines are ignored when
+// computing the common prefix and are emptied.
+func dedent(text string) string {
+	lines := strings.Split(text, "\n")
+	prefix := ""
+	first := true
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			prefix = indent
+			first = false
+			continue
+		}
+		for !strings.HasPrefix(indent, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+		} else {
+			lines[i] = line[len(prefix):]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkJSONDepth reports an error if text nests objects and arrays deeper
+// than MaxJSONDepth allows.
+func (p *Parser) checkJSONDepth(originalName, text string) *ParseError {
+	limit := p.opts.MaxJSONDepth
+	if limit == 0 {
+		limit = defaultMaxJSONDepth
+	}
+	if limit < 0 {
+		return nil
+	}
+	var balance jsonBalance
+	balance.feed(text)
+	if balance.maxDepth <= limit {
+		return nil
+	}
+	return &ParseError{
+		Code:    CodeJSONDepth,
+		Label:   originalName,
+		Message: "JSON in '" + originalName + "' exceeds the maximum nesting depth of " + strconv.Itoa(limit),
+	}
+}
+
+// parseJSONEntry unmarshals the value of a JSON label. If that fails, the
+// text is retried with Unicode punctuation normalized when
+// NormalizeUnicodePunctuation is set, so valid JSON keeps any typography in
+// its strings. If unmarshaling still fails and a JSONRepair callback is set,
+// the repaired text is tried before the error is reported. The text that
+// unmarshaled is returned alongside its value.
+func (p *Parser) parseJSONEntry(originalName, entry string) (interface{}, string, *ParseError) {
+	if p.opts.UnwrapOuterFenceOnly {
+		entry = unwrapOuterFence(entry)
+	}
+	if depthErr := p.checkJSONDepth(originalName, entry); depthErr != nil {
+		return nil, "", depthErr
+	}
+	var obj interface{}
+	err := json.Unmarshal([]byte(entry), &obj)
+	if err != nil && p.opts.NormalizeUnicodePunctuation {
+		if normalized := unicodePunctuationReplacer.Replace(entry); normalized != entry {
+			entry = normalized
+			err = json.Unmarshal([]byte(entry), &obj)
+		}
+	}
+	if err != nil {
+		if p.opts.JSONRepair != nil {
+			if repaired, ok := p.opts.JSONRepair(originalName, entry); ok {
+				if depthErr := p.checkJSONDepth(originalName, repaired); depthErr != nil {
+					return nil, "", depthErr
+				}
+				if json.Unmarshal([]byte(repaired), &obj) == nil {
+					return obj, repaired, nil
+				}
+			}
+		}
+		return nil, "", &ParseError{
+			Code:    CodeJSON,
+			Label:   originalName,
+			Message: "JSON error in '" + originalName + "': " + err.Error(),
+			Err:     err,
+		}
+	}
+	return obj, entry, nil
+}
+
+// decodeJSONTarget decodes the JSON text data, already parsed as obj, into a
+// new value of the label's JSONTarget type. Decoding the text rather than obj
+// keeps integers beyond float64 precision intact. On failure the generic value
+// is returned alongside the error.
+func decodeJSONTarget(originalName string, labelDef Label, obj interface{}, data string) (interface{}, *ParseError) {
+	targetType := reflect.TypeOf(labelDef.JSONTarget)
+	isPtr := targetType.Kind() == reflect.Pointer
+	if isPtr {
+		targetType = targetType.Elem()
+	}
+	target := reflect.New(targetType)
+
+	decoder := json.NewDecoder(strings.NewReader(data))
+	if labelDef.DisallowUnknownJSONKeys {
+		decoder.DisallowUnknownFields()
+	}
+	if err := decoder.Decode(target.Interface()); err != nil {
+		// encoding/json reports unknown keys only as `json: unknown field "xyz"`
+		if field, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			if unquoted, unquoteErr := strconv.Unquote(field); unquoteErr == nil {
+				field = unquoted
+			}
+			return obj, &ParseError{
+				Code:    CodeUnknownJSONKey,
+				Label:   originalName,
+				Message: "'" + originalName + "' has unknown field '" + field + "'",
+				Err:     err,
+			}
+		}
+		return obj, &ParseError{Code: CodeJSON, Label: originalName, Message: "JSON error in '" + originalName + "': " + err.Error(), Err: err}
+	}
+	if isPtr {
+		return target.Interface(), nil
+	}
+	return target.Elem().Interface(), nil
+}
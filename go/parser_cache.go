@@ -0,0 +1,114 @@
+package structuredparse
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/json"
+	"sync"
+)
+
+// defaultParserCacheSize is the number of compiled parsers kept by default.
+const defaultParserCacheSize = 32
+
+// parserCache is a size-bounded LRU cache of compiled parsers keyed by a hash
+// of their labels and options. It lets the WASM layer reuse a Parser across
+// repeated requests with the same configuration.
+type parserCache struct {
+	mu      sync.Mutex
+	maxSize int
+	order   *list.List               // Most recently used at the front
+	entries map[string]*list.Element // Key -> element holding a *parserCacheEntry
+}
+
+type parserCacheEntry struct {
+	key    string
+	parser *Parser
+}
+
+// newParserCache creates a cache holding at most maxSize parsers.
+// A maxSize of zero or less disables caching.
+func newParserCache(maxSize int) *parserCache {
+	return &parserCache{
+		maxSize: maxSize,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// parserCacheKey hashes the JSON encoding of the labels and options.
+// Function-valued options such as JSONRepair are not part of the key.
+func parserCacheKey(labels []Label, opts *ParserOptions) (string, error) {
+	data, err := json.Marshal(struct {
+		Labels  []Label        `json:"labels"`
+		Options *ParserOptions `json:"options"`
+	}{labels, opts})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return string(sum[:]), nil
+}
+
+// get returns the cached parser for the labels and options, building and
+// caching it with NewParser on a miss. Construction errors are not cached.
+func (c *parserCache) get(labels []Label, opts *ParserOptions) (*Parser, error) {
+	key, err := parserCacheKey(labels, opts)
+	if err != nil || c.maxSize <= 0 {
+		return NewParser(labels, opts)
+	}
+
+	c.mu.Lock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*parserCacheEntry).parser, nil
+	}
+	c.mu.Unlock()
+
+	parser, err := NewParser(labels, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.order.MoveToFront(elem)
+		return elem.Value.(*parserCacheEntry).parser, nil
+	}
+	c.entries[key] = c.order.PushFront(&parserCacheEntry{key: key, parser: parser})
+	c.evict()
+	return parser, nil
+}
+
+// setMaxSize changes the capacity, evicting least recently used parsers as needed.
+func (c *parserCache) setMaxSize(maxSize int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxSize = maxSize
+	c.evict()
+}
+
+// clear removes every cached parser.
+func (c *parserCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+// len returns the number of cached parsers.
+func (c *parserCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// evict drops least recently used entries beyond maxSize. The caller must hold mu.
+func (c *parserCache) evict() {
+	for c.order.Len() > 0 && c.order.Len() > c.maxSize {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*parserCacheEntry).key)
+	}
+}
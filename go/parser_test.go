@@ -1,10 +1,17 @@
 package structuredparse
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"unicode/utf8"
 )
 
 // Test scaffolding for parser, will load test cases from assets.
@@ -52,50 +59,10 @@ func TestBasicFunctionality(t *testing.T) {
 }
 
 // deepEqual is a helper for comparing parser outputs in tests.
-// It recursively compares maps, slices, and primitive types for equality.
+// It delegates to ResultsEqual, which handles the block-slice type normalization.
 func deepEqual(t *testing.T, a, b interface{}) bool {
 	t.Helper()
-	if reflect.TypeOf(a) != reflect.TypeOf(b) {
-		return false
-	}
-	switch aVal := a.(type) {
-	case map[string]interface{}:
-		bVal := b.(map[string]interface{})
-		if len(aVal) != len(bVal) {
-			return false
-		}
-		for k, v := range aVal {
-			if !deepEqual(t, v, bVal[k]) {
-				return false
-			}
-		}
-		return true
-	case []interface{}:
-		bVal, ok := b.([]interface{})
-		if !ok || len(aVal) != len(bVal) {
-			return false
-		}
-		for i := range aVal {
-			if !deepEqual(t, aVal[i], bVal[i]) {
-				return false
-			}
-		}
-		return true
-	// Handle slices of maps by converting both to []interface{} and comparing recursively
-	case []map[string]interface{}:
-		// Convert both a and b to []interface{}
-		ai := make([]interface{}, len(aVal))
-		for i := range aVal {
-			ai[i] = aVal[i]
-		}
-		bi := make([]interface{}, len(b.([]map[string]interface{})))
-		for i := range b.([]map[string]interface{}) {
-			bi[i] = b.([]map[string]interface{})[i]
-		}
-		return deepEqual(t, ai, bi)
-	default:
-		return reflect.DeepEqual(a, b)
-	}
+	return ResultsEqual(a, b)
 }
 
 // TestMixedCaseMultiline checks handling of mixed case labels and multiline values.
@@ -413,3 +380,3342 @@ func TestEqualsSeparator(t *testing.T) {
 		t.Errorf("expected Age='30', got %v", result["Age"])
 	}
 }
+
+// TestRequiredWithNestedJSONPath verifies that RequiredWith can reach into a JSON label's contents.
+func TestRequiredWithNestedJSONPath(t *testing.T) {
+	labels := []Label{
+		{Name: "Action", RequiredWith: []string{"Action Input.id"}},
+		{Name: "Action Input", IsJSON: true},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	// Nested key present - no errors
+	_, errs := parser.Parse("Action: lookup\nAction Input: {\"id\": 7}")
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	// Nested key missing - error names the key
+	_, errs = parser.Parse("Action: lookup\nAction Input: {\"name\": \"x\"}")
+	expected := "'Action' requires 'Action Input' to contain key 'id'"
+	if len(errs) != 1 || errs[0] != expected {
+		t.Errorf("expected [%q], got %v", expected, errs)
+	}
+
+	// Parent label missing entirely - plain dependency error
+	_, errs = parser.Parse("Action: lookup")
+	expected = "'Action' requires 'Action Input'"
+	if len(errs) != 1 || errs[0] != expected {
+		t.Errorf("expected [%q], got %v", expected, errs)
+	}
+
+	// A JSONTarget label is checked against the JSON, not the typed value
+	type input struct {
+		ID int `json:"id"`
+	}
+	typed, err := NewParser([]Label{
+		{Name: "Action", RequiredWith: []string{"Action Input.id"}},
+		{Name: "Action Input", IsJSON: true, JSONTarget: input{}},
+	}, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, errs := typed.Parse("Action: lookup\nAction Input: {\"id\": 7}")
+	if len(errs) > 0 || result["Action Input"] != (input{ID: 7}) {
+		t.Errorf("expected a typed value and no errors, got %#v %v", result["Action Input"], errs)
+	}
+	_, errs = typed.Parse("Action: lookup\nAction Input: {}")
+	expected = "'Action' requires 'Action Input' to contain key 'id'"
+	if len(errs) != 1 || errs[0] != expected {
+		t.Errorf("expected [%q], got %v", expected, errs)
+	}
+}
+
+// TestParseWithOverride verifies that ParseWith applies options for a single call only.
+func TestParseWithOverride(t *testing.T) {
+	labels := []Label{
+		{Name: "Key"},
+		{Name: "Value"},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	text := "Key: colon\nValue=equals"
+	result, _ := parser.ParseWith(text, &ParserOptions{Separators: "="})
+	if result["Key"] != "" {
+		t.Errorf("expected Key to be empty with '=' override, got %v", result["Key"])
+	}
+	if result["Value"] != "equals" {
+		t.Errorf("expected Value='equals', got %v", result["Value"])
+	}
+
+	// The parser itself is unchanged
+	result, _ = parser.Parse(text)
+	if result["Key"] != "colon" {
+		t.Errorf("expected Key='colon' after override call, got %v", result["Key"])
+	}
+
+	// A nil override behaves like Parse
+	result, _ = parser.ParseWith(text, nil)
+	if result["Key"] != "colon" {
+		t.Errorf("expected Key='colon' with nil override, got %v", result["Key"])
+	}
+
+	// Options other than separators reach nested labels too
+	nested, err := NewParser([]Label{{Name: "Details", NestedLabels: []Label{{Name: "Cfg", IsJSON: true}}}}, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, _ = nested.ParseWith("Details:\n  Cfg:", &ParserOptions{EmptyJSONAsNull: true})
+	if details, _ := result["Details"].(map[string]interface{}); details == nil || details["Cfg"] != nil {
+		t.Errorf("expected a null nested Cfg, got %#v", result["Details"])
+	}
+
+	// Separators found in a label name are rejected as by SetSeparators
+	named, err := NewParser([]Label{{Name: "A=B"}}, &ParserOptions{Separators: ":"})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	if _, errs := named.ParseWith("A=B: x", &ParserOptions{Separators: "="}); len(errs) != 1 || !strings.Contains(errs[0], "contains the separator '='") {
+		t.Errorf("expected a separator conflict error, got %v", errs)
+	}
+}
+
+// TestRequireSpaceAfterSeparator verifies that a space can be required after the separator.
+func TestRequireSpaceAfterSeparator(t *testing.T) {
+	labels := []Label{
+		{Name: "Time"},
+		{Name: "Ratio"},
+	}
+
+	parser, err := NewParser(labels, &ParserOptions{RequireSpaceAfterSeparator: true})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	result, errs := parser.Parse("Time: 3:30\nRatio:3")
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if result["Time"] != "3:30\nRatio:3" {
+		t.Errorf("expected compact Ratio line to be continuation text, got Time=%q", result["Time"])
+	}
+	if result["Ratio"] != "" {
+		t.Errorf("expected Ratio to be empty, got %v", result["Ratio"])
+	}
+
+	// Without the option the compact form matches
+	relaxed, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, _ = relaxed.Parse("Ratio:3")
+	if result["Ratio"] != "3" {
+		t.Errorf("expected Ratio='3', got %v", result["Ratio"])
+	}
+}
+
+// TestParseBlocksToJSONL verifies that blocks are written as one JSON object per line.
+func TestParseBlocksToJSONL(t *testing.T) {
+	labels := []Label{
+		{Name: "Task", IsBlockStart: true}, {Name: "Result"},
+	}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	var buf bytes.Buffer
+	errs := parser.ParseBlocksToJSONL("Task: one\nResult: ok\nTask: two\nResult: fail", &buf)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	expected := "{\"Result\":\"ok\",\"Task\":\"one\"}\n{\"Result\":\"fail\",\"Task\":\"two\"}\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected JSONL output.\nGot: %q\nExpected: %q", buf.String(), expected)
+	}
+}
+
+// TestImplicitFirstBlock verifies that content before the first block start can form block 0.
+func TestImplicitFirstBlock(t *testing.T) {
+	labels := []Label{
+		{Name: "Task", IsBlockStart: true}, {Name: "Result"},
+	}
+	text := "Result: first\nTask: two\nResult: second"
+
+	parser, err := NewParser(labels, &ParserOptions{ImplicitFirstBlock: true})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	blocks, errs := parser.ParseBlocks(text)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("expected 2 blocks, got %d: %v", len(blocks), blocks)
+	}
+	if blocks[0]["Result"] != "first" || blocks[0]["Task"] != "" {
+		t.Errorf("unexpected implicit block: %v", blocks[0])
+	}
+	if blocks[1]["Task"] != "two" {
+		t.Errorf("unexpected second block: %v", blocks[1])
+	}
+
+	// A preamble without any labels does not create a block
+	blocks, _ = parser.ParseBlocks("Here is the output.\nTask: one\nResult: ok")
+	if len(blocks) != 1 {
+		t.Errorf("expected preamble to be dropped, got %d blocks: %v", len(blocks), blocks)
+	}
+
+	// Default behavior drops the leading content
+	strict, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	blocks, _ = strict.ParseBlocks(text)
+	if len(blocks) != 1 {
+		t.Errorf("expected 1 block without ImplicitFirstBlock, got %d", len(blocks))
+	}
+}
+
+// TestFuzzyLabelDistance verifies misspelled labels match the closest declared label.
+func TestFuzzyLabelDistance(t *testing.T) {
+	labels := []Label{
+		{Name: "Thought"},
+		{Name: "Action"},
+		{Name: "Action Input"},
+	}
+
+	parser, err := NewParser(labels, &ParserOptions{FuzzyLabelDistance: 1})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	result, errs := parser.Parse("Thoght: thinking\nActon: search\nAction Inptu: query")
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if result["Thought"] != "thinking" {
+		t.Errorf("expected Thought='thinking', got %v", result["Thought"])
+	}
+	if result["Action"] != "search\nAction Inptu: query" {
+		t.Errorf("expected distance-2 misspelling to remain continuation text, got Action=%q", result["Action"])
+	}
+
+	// Without the option misspellings are continuation text
+	exact, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, _ = exact.Parse("Thought: a\nActon: b")
+	if result["Thought"] != "a\nActon: b" || result["Action"] != "" {
+		t.Errorf("unexpected fuzzy match with option disabled: %v", result)
+	}
+
+	// Ties resolve to the first declared label
+	tied, err := NewParser([]Label{{Name: "Cat"}, {Name: "Car"}}, &ParserOptions{FuzzyLabelDistance: 1})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, _ = tied.Parse("Cax: value")
+	if result["Cat"] != "value" || result["Car"] != "" {
+		t.Errorf("expected tie to resolve to Cat, got %v", result)
+	}
+}
+
+// TestSetSeparators verifies that separators can be changed on an existing parser.
+func TestSetSeparators(t *testing.T) {
+	labels := []Label{
+		{Name: "Key"},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	if err := parser.SetSeparators("|"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	result, _ := parser.Parse("Key| piped")
+	if result["Key"] != "piped" {
+		t.Errorf("expected Key='piped', got %v", result["Key"])
+	}
+	result, _ = parser.Parse("Key: colon")
+	if result["Key"] != "" {
+		t.Errorf("expected colon to no longer match, got %v", result["Key"])
+	}
+
+	if err := parser.SetSeparators(""); err == nil {
+		t.Error("expected error for empty separators")
+	}
+}
+
+// TestSeparatorsReachNestedLabels verifies that ParseWith and SetSeparators
+// change the separators of nested label parsers too.
+func TestSeparatorsReachNestedLabels(t *testing.T) {
+	labels := []Label{{Name: "Details", NestedLabels: []Label{{Name: "Name"}}}}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	text := "Details| x\n  Name| bob"
+	expected := map[string]interface{}{"Name": "bob"}
+
+	result, errs := parser.ParseWith(text, &ParserOptions{Separators: "|"})
+	if len(errs) > 0 || !reflect.DeepEqual(result["Details"], expected) {
+		t.Errorf("expected ParseWith to reach nested labels, got %v %v", result, errs)
+	}
+	if result, _ := parser.Parse("Details: x\n  Name: bob"); !reflect.DeepEqual(result["Details"], expected) {
+		t.Errorf("expected ParseWith to leave the parser unchanged, got %v", result)
+	}
+
+	if err := parser.SetSeparators("|"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result, _ := parser.Parse(text); !reflect.DeepEqual(result["Details"], expected) {
+		t.Errorf("expected SetSeparators to reach nested labels, got %v", result)
+	}
+}
+
+// TestSeparatorsReachBlockFields verifies that ParseWith and SetSeparators
+// change the separators of per-block BlockFields parsers too.
+func TestSeparatorsReachBlockFields(t *testing.T) {
+	labels := []Label{
+		{Name: "Task", IsBlockStart: true, BlockFields: []string{"Status"}},
+		{Name: "Note", IsBlockStart: true, BlockFields: []string{"Body"}},
+		{Name: "Status"}, {Name: "Body"},
+	}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	text := "Task| a\nStatus| done\nNote| b\nBody| text"
+	expected := []map[string]interface{}{{"Task": "a", "Status": "done"}, {"Note": "b", "Body": "text"}}
+
+	alternate, err := parser.withOptions(&ParserOptions{Separators: "|"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if blocks, errs := alternate.ParseBlocks(text); len(errs) > 0 || !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("expected overridden separators in block fields, got %v %v", blocks, errs)
+	}
+
+	if err := parser.SetSeparators("|"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if blocks, errs := parser.ParseBlocks(text); len(errs) > 0 || !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("expected SetSeparators to reach block fields, got %v %v", blocks, errs)
+	}
+}
+
+// TestBlockIntroKey verifies that text after the block start line is captured separately.
+func TestBlockIntroKey(t *testing.T) {
+	labels := []Label{
+		{Name: "Task", IsBlockStart: true}, {Name: "Result"},
+	}
+	text := "Task: Task 1\nSome intro text\nspanning lines\nResult: ok\nTask: Task 2\nResult: done"
+
+	parser, err := NewParser(labels, &ParserOptions{BlockIntroKey: "Intro"})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	blocks, errs := parser.ParseBlocks(text)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	expected := []map[string]interface{}{
+		{"Task": "Task 1", "Intro": "Some intro text\nspanning lines", "Result": "ok"},
+		{"Task": "Task 2", "Intro": "", "Result": "done"},
+	}
+	if !deepEqual(t, blocks, expected) {
+		t.Errorf("block result mismatch.\nGot: %#v\nExpected: %#v", blocks, expected)
+	}
+}
+
+// TestParseETypedErrors verifies that ParseE returns structured errors matching the sentinels.
+func TestParseETypedErrors(t *testing.T) {
+	labels := []Label{
+		{Name: "Result", Required: true},
+		{Name: "Action", RequiredWith: []string{"Input"}},
+		{Name: "Input", IsJSON: true},
+		{Name: "Config", IsJSON: true},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	_, errs := parser.ParseE("Action: run\nConfig: {invalid}")
+	if len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %v", errs)
+	}
+
+	counts := map[ErrorCode]int{}
+	for _, e := range errs {
+		counts[e.Code]++
+		switch e.Code {
+		case CodeRequired:
+			if !errors.Is(e, ErrRequired) || e.Label != "Result" {
+				t.Errorf("unexpected required error: %#v", e)
+			}
+		case CodeRequiredWith:
+			if !errors.Is(e, ErrRequiredWith) || e.Label != "Action" || e.Dependency != "Input" {
+				t.Errorf("unexpected dependency error: %#v", e)
+			}
+		case CodeJSON:
+			var syntaxErr *json.SyntaxError
+			if !errors.Is(e, ErrJSON) || !errors.As(e, &syntaxErr) || e.Label != "Config" {
+				t.Errorf("unexpected JSON error: %#v", e)
+			}
+		}
+	}
+	if counts[CodeRequired] != 1 || counts[CodeRequiredWith] != 1 || counts[CodeJSON] != 1 {
+		t.Errorf("unexpected error codes: %v", counts)
+	}
+
+	// The string API reports the same messages
+	_, stringErrs := parser.Parse("Action: run\nConfig: {invalid}")
+	if len(stringErrs) != len(errs) {
+		t.Errorf("expected %d string errors, got %v", len(errs), stringErrs)
+	}
+}
+
+// TestMaxLineLength verifies that overlong lines are truncated and optionally reported.
+func TestMaxLineLength(t *testing.T) {
+	labels := []Label{
+		{Name: "Data"},
+	}
+	text := "Data: " + strings.Repeat("x", 100)
+
+	parser, err := NewParser(labels, &ParserOptions{MaxLineLength: 16})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse(text)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if result["Data"] != strings.Repeat("x", 10) {
+		t.Errorf("expected truncated value, got %q", result["Data"])
+	}
+
+	strict, err := NewParser(labels, &ParserOptions{MaxLineLength: 16, ErrorOnLongLine: true})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	_, typedErrs := strict.ParseE(text)
+	if len(typedErrs) != 1 || !errors.Is(typedErrs[0], ErrLineTooLong) {
+		t.Errorf("expected a single line-too-long error, got %v", typedErrs)
+	}
+	if len(typedErrs) == 1 && typedErrs[0].Message != "line 1 exceeds maximum length of 16" {
+		t.Errorf("unexpected message: %q", typedErrs[0].Message)
+	}
+}
+
+// TestGetPath verifies JSON pointer lookups into parse results.
+func TestGetPath(t *testing.T) {
+	labels := []Label{
+		{Name: "Config", IsJSON: true},
+		{Name: "Name"},
+		{Name: "Tags", SplitOn: ","},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse(`Config: {"threshold": 0.5, "steps": ["a", "b"], "a/b": {"~x": true}}
+Name: test
+Tags: x, y`)
+	if len(errs) > 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+
+	cases := []struct {
+		pointer string
+		want    interface{}
+		ok      bool
+	}{
+		{"/Config/threshold", 0.5, true},
+		{"/Config/steps/1", "b", true},
+		{"/Config/a~1b/~0x", true, true},
+		{"/Name", "test", true},
+		{"/Tags/1", "y", true},
+		{"/Tags/2", nil, false},
+		{"/Config/missing", nil, false},
+		{"/Config/steps/5", nil, false},
+		{"Config", nil, false},
+	}
+	for _, c := range cases {
+		got, ok := GetPath(result, c.pointer)
+		if ok != c.ok || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("GetPath(%q) = %v, %v; want %v, %v", c.pointer, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+// TestRepeatableLabels verifies repeatable labels always produce slices and duplicates are enforced.
+func TestRepeatableLabels(t *testing.T) {
+	labels := []Label{
+		{Name: "Step", Repeatable: true},
+		{Name: "Conclusion"},
+	}
+	text := "Step: one\nConclusion: first\nConclusion: second"
+
+	parser, err := NewParser(labels, &ParserOptions{DuplicatePolicy: DuplicateKeepFirst})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse(text)
+	if !reflect.DeepEqual(result["Step"], []interface{}{"one"}) {
+		t.Errorf("expected Step to be a slice, got %#v", result["Step"])
+	}
+	if result["Conclusion"] != "first" {
+		t.Errorf("expected first Conclusion to be kept, got %v", result["Conclusion"])
+	}
+	expected := "'Conclusion' appears multiple times but is not repeatable"
+	if len(errs) != 1 || errs[0] != expected {
+		t.Errorf("expected [%q], got %v", expected, errs)
+	}
+
+	result, _ = parser.ParseWith(text, &ParserOptions{DuplicatePolicy: DuplicateKeepLast})
+	if result["Conclusion"] != "second" {
+		t.Errorf("expected last Conclusion to be kept, got %v", result["Conclusion"])
+	}
+
+	// The default policy collects duplicates with a warning
+	collect, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	collected, parseErrs := collect.ParseE(text)
+	if len(parseErrs) != 1 || !parseErrs[0].Warning || !errors.Is(parseErrs[0], ErrNotRepeatable) ||
+		parseErrs[0].Message != "warning: 'Conclusion' appears multiple times but is not repeatable; keeping every value" {
+		t.Errorf("expected a not-repeatable warning, got %v", parseErrs)
+	}
+	result = collected
+	if !reflect.DeepEqual(result["Conclusion"], []interface{}{"first", "second"}) {
+		t.Errorf("expected both Conclusions, got %#v", result["Conclusion"])
+	}
+}
+
+// TestNormalizeUnicodePunctuation verifies smart quotes and dashes are fixed in JSON fields only.
+func TestNormalizeUnicodePunctuation(t *testing.T) {
+	labels := []Label{
+		{Name: "Input", IsJSON: true},
+		{Name: "Note"},
+	}
+	text := "Input: {“query”: “A–B”}\nNote: “quoted” — prose"
+
+	parser, err := NewParser(labels, &ParserOptions{NormalizeUnicodePunctuation: true})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse(text)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	expected := map[string]interface{}{"query": "A-B"}
+	if !reflect.DeepEqual(result["Input"], expected) {
+		t.Errorf("expected Input=%v, got %#v", expected, result["Input"])
+	}
+	if result["Note"] != "“quoted” — prose" {
+		t.Errorf("expected Note typography to be preserved, got %q", result["Note"])
+	}
+
+	// Valid JSON is decoded as written, typography in strings included
+	result, errs = parser.Parse(`Input: {"quote": "he said “hi” — ok"}`)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if expected := map[string]interface{}{"quote": "he said “hi” — ok"}; !reflect.DeepEqual(result["Input"], expected) {
+		t.Errorf("expected Input=%v, got %#v", expected, result["Input"])
+	}
+
+	// Without the option the JSON fails to parse
+	plain, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	if _, errs := plain.Parse(text); len(errs) != 1 {
+		t.Errorf("expected a JSON error without normalization, got %v", errs)
+	}
+}
+
+// TestConfigDiff verifies that parser configurations can be compared.
+func TestConfigDiff(t *testing.T) {
+	labels := []Label{
+		{Name: "Action", Required: true},
+		{Name: "Input", IsJSON: true},
+	}
+
+	a, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	b, err := NewParser(labels, &ParserOptions{Separators: ":~-="})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	if !a.ConfigEqual(b) {
+		t.Errorf("expected equal configs, got diff: %v", a.ConfigDiff(b))
+	}
+
+	c, err := NewParser([]Label{{Name: "Action"}, {Name: "Input", IsJSON: true}}, &ParserOptions{Separators: ":"})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	diff := a.ConfigDiff(c)
+	expected := []string{
+		"label[0] (Action): Required differs: true vs false",
+		"options: Separators differs: :~-= vs :",
+	}
+	if !reflect.DeepEqual(diff, expected) {
+		t.Errorf("unexpected diff.\nGot: %#v\nExpected: %#v", diff, expected)
+	}
+	if a.ConfigEqual(c) {
+		t.Error("expected configs to differ")
+	}
+}
+
+// TestConfigRoundTrip verifies that a parser config survives JSON serialization.
+func TestConfigRoundTrip(t *testing.T) {
+	labels := []Label{
+		{Name: "Action", RequiredWith: []string{"Action Input"}},
+		{Name: "Action Input", IsJSON: true},
+		{Name: "Step", Repeatable: true},
+	}
+
+	original, err := NewParser(labels, &ParserOptions{Separators: ":=", DuplicatePolicy: DuplicateKeepLast})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	data, err := json.Marshal(original.Config())
+	if err != nil {
+		t.Fatalf("failed to marshal config: %v", err)
+	}
+	var decoded ParserConfig
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal config: %v", err)
+	}
+	restored, err := NewParserFromConfig(decoded)
+	if err != nil {
+		t.Fatalf("failed to create parser from config: %v", err)
+	}
+
+	if diff := original.ConfigDiff(restored); len(diff) > 0 {
+		t.Errorf("restored parser differs: %v", diff)
+	}
+	if decoded.Labels[1].Name != "Action Input" {
+		t.Errorf("expected original label casing, got %q", decoded.Labels[1].Name)
+	}
+}
+
+// TestBlockStartInsideJSON verifies that a block-start label inside a JSON value does not split the block.
+func TestBlockStartInsideJSON(t *testing.T) {
+	labels := []Label{
+		{Name: "Task", IsBlockStart: true}, {Name: "Input", IsJSON: true}, {Name: "Result"},
+	}
+	text := `Task: one
+Input: {
+  "Task": "nested",
+  "items": [
+Task: not a block
+  ]
+}
+Result: ok
+Task: two
+Result: done`
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	var splits int
+	for _, line := range strings.Split(text, "\n") {
+		if name, _ := parser.parseLine(line); name == "task" {
+			splits++
+		}
+	}
+	if splits != 3 {
+		t.Fatalf("expected the embedded line to look like a block start, got %d matches", splits)
+	}
+
+	blocks, errs := parser.ParseBlocks(text)
+	if len(blocks) != 2 {
+		t.Fatalf("expected 2 blocks, got %d: %v", len(blocks), blocks)
+	}
+	// The embedded line stays in the value, which is then not valid JSON as
+	// a whole; it must not end the value or become a second Task.
+	rawInput := "{\n  \"Task\": \"nested\",\n  \"items\": [\nTask: not a block\n  ]\n}"
+	if blocks[0]["Task"] != "one" || blocks[0]["Input"] != rawInput || blocks[0]["Result"] != "ok" {
+		t.Errorf("unexpected first block: %q", blocks[0])
+	}
+	if len(errs) != 1 || !strings.HasPrefix(errs[0], "JSON error in 'Input': invalid character 'T'") {
+		t.Errorf("expected only the JSON error for the whole value, got %v", errs)
+	}
+	if blocks[1]["Task"] != "two" {
+		t.Errorf("unexpected second block: %v", blocks[1])
+	}
+
+	// With quotes stripped before matching, a quoted string in valid JSON
+	// looks like a block start too.
+	quoted, err := NewParser(labels, &ParserOptions{StripLabelPrefixRunes: `"`})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	blocks, errs = quoted.ParseBlocks("Task: one\nInput: {\n  \"items\": [\n    \"Task: not a block\"\n  ]\n}\nResult: ok\nTask: two")
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	expectedInput := map[string]interface{}{"items": []interface{}{"Task: not a block"}}
+	if len(blocks) != 2 || blocks[0]["Task"] != "one" || !reflect.DeepEqual(blocks[0]["Input"], expectedInput) {
+		t.Errorf("unexpected blocks: %v", blocks)
+	}
+
+	// JSON opening on the line after its label is tracked the same way.
+	nextLine := "Input:\n{\n  \"items\": [\nTask: not a block\n  ]\n}"
+	result, errs := parser.Parse(nextLine)
+	rawInput = "{\n  \"items\": [\nTask: not a block\n  ]\n}"
+	if result["Input"] != rawInput || result["Task"] != "" {
+		t.Errorf("expected the embedded line to stay in Input, got %q", result)
+	}
+	if len(errs) != 1 || !strings.HasPrefix(errs[0], "JSON error in 'Input': invalid character 'T'") {
+		t.Errorf("expected only the JSON error for the whole value, got %v", errs)
+	}
+}
+
+// TestParseBlocksByKey verifies that blocks are indexed by a key field.
+func TestParseBlocksByKey(t *testing.T) {
+	labels := []Label{
+		{Name: "Task", IsBlockStart: true}, {Name: "ID"}, {Name: "Result"},
+	}
+	text := "Task: a\nID: t1\nResult: first\nTask: b\nID: t2\nTask: c\nID: t1\nResult: dup\nTask: d"
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	indexed, errs := parser.ParseBlocksByKey(text, "id")
+	if len(indexed) != 2 {
+		t.Fatalf("expected 2 indexed blocks, got %d: %v", len(indexed), indexed)
+	}
+	if indexed["t1"]["Result"] != "first" {
+		t.Errorf("expected first t1 block to be kept, got %v", indexed["t1"])
+	}
+	expectedErrs := []string{
+		"duplicate 'ID' value 't1' in block 3",
+		"block 4 has no 'ID' value",
+	}
+	if !reflect.DeepEqual(errs, expectedErrs) {
+		t.Errorf("unexpected errors.\nGot: %#v\nExpected: %#v", errs, expectedErrs)
+	}
+
+	keepLast, _ := parser.withOptions(&ParserOptions{DuplicatePolicy: DuplicateKeepLast})
+	indexed, _ = keepLast.ParseBlocksByKey(text, "ID")
+	if indexed["t1"]["Result"] != "dup" {
+		t.Errorf("expected last t1 block to be kept, got %v", indexed["t1"])
+	}
+}
+
+// TestMultiByteSeparator verifies that multi-byte separators such as arrows work.
+func TestMultiByteSeparator(t *testing.T) {
+	labels := []Label{
+		{Name: "Action"},
+		{Name: "Thought"},
+	}
+
+	parser, err := NewParser(labels, &ParserOptions{Separators: ":→"})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse("Thought: go\nAction → run")
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if result["Action"] != "run" {
+		t.Errorf("expected Action='run', got %q", result["Action"])
+	}
+	if result["Thought"] != "go" {
+		t.Errorf("expected Thought='go', got %q", result["Thought"])
+	}
+
+	// The arrow is stripped as a whole rune, not byte by byte
+	result, _ = parser.Parse("Action →→ walk")
+	if result["Action"] != "walk" {
+		t.Errorf("expected Action='walk', got %q", result["Action"])
+	}
+}
+
+// TestJSONRepair verifies that a repair callback can fix invalid JSON before an error is recorded.
+func TestJSONRepair(t *testing.T) {
+	labels := []Label{
+		{Name: "Input", IsJSON: true},
+	}
+
+	var calls []string
+	repair := func(label, raw string) (string, bool) {
+		calls = append(calls, label)
+		if strings.HasSuffix(raw, ",}") {
+			return strings.TrimSuffix(raw, ",}") + "}", true
+		}
+		return "", false
+	}
+
+	parser, err := NewParser(labels, &ParserOptions{JSONRepair: repair})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	result, errs := parser.Parse(`Input: {"a": 1,}`)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if !reflect.DeepEqual(result["Input"], map[string]interface{}{"a": float64(1)}) {
+		t.Errorf("expected repaired JSON, got %#v", result["Input"])
+	}
+	if len(calls) != 1 || calls[0] != "Input" {
+		t.Errorf("expected one repair call for 'Input', got %v", calls)
+	}
+
+	// Declined repair keeps the raw value and records the error
+	result, errs = parser.Parse(`Input: {broken`)
+	if len(errs) != 1 || result["Input"] != "{broken" {
+		t.Errorf("expected raw value and one error, got %v, %v", result["Input"], errs)
+	}
+}
+
+// TestNestedLabels verifies that indented sub-labels are parsed into a nested map.
+func TestNestedLabels(t *testing.T) {
+	labels := []Label{
+		{Name: "Summary"},
+		{Name: "Details", NestedLabels: []Label{
+			{Name: "Name"},
+			{Name: "Summary", Required: true},
+		}},
+		{Name: "Status"},
+	}
+	text := `Summary: top
+Details:
+  Name: widget
+  Summary: nested summary
+Status: done`
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse(text)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+
+	expected := map[string]interface{}{
+		"Summary": "top",
+		"Details": map[string]interface{}{"Name": "widget", "Summary": "nested summary"},
+		"Status":  "done",
+	}
+	if !deepEqual(t, result, expected) {
+		t.Errorf("result mismatch.\nGot: %#v\nExpected: %#v", result, expected)
+	}
+
+	// Nested validation errors are reported
+	_, errs = parser.Parse("Details:\n  Name: widget")
+	if len(errs) != 1 || errs[0] != "'Summary' is required" {
+		t.Errorf("expected nested required error, got %v", errs)
+	}
+}
+
+// TestEmptyJSONAsNull verifies empty JSON fields become null and count as present.
+func TestEmptyJSONAsNull(t *testing.T) {
+	labels := []Label{
+		{Name: "Action"},
+		{Name: "Input", IsJSON: true, Required: true},
+	}
+
+	parser, err := NewParser(labels, &ParserOptions{EmptyJSONAsNull: true})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse("Input:\nAction: run")
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if v, ok := result["Input"]; !ok || v != nil {
+		t.Errorf("expected Input to be nil, got %#v", v)
+	}
+
+	// An absent JSON label is still missing
+	_, errs = parser.Parse("Action: run")
+	if len(errs) != 1 || errs[0] != "'Input' is required" {
+		t.Errorf("expected required error for absent Input, got %v", errs)
+	}
+
+	// By default an empty JSON label is an empty object
+	plain, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, _ = plain.Parse("Input:\nAction: run")
+	if !reflect.DeepEqual(result["Input"], map[string]interface{}{}) {
+		t.Errorf("expected Input to be an empty object, got %#v", result["Input"])
+	}
+}
+
+// TestResultsEqual verifies the exported result comparison utility.
+func TestResultsEqual(t *testing.T) {
+	blocks := []map[string]interface{}{
+		{"Task": "a", "Input": map[string]interface{}{"n": float64(1)}},
+	}
+	decoded := []interface{}{
+		map[string]interface{}{"Task": "a", "Input": map[string]interface{}{"n": float64(1)}},
+	}
+	if !ResultsEqual(blocks, decoded) {
+		t.Error("expected block slice to equal decoded JSON slice")
+	}
+	if !ResultsEqual(decoded, blocks) {
+		t.Error("expected comparison to be symmetric")
+	}
+
+	different := []interface{}{
+		map[string]interface{}{"Task": "a", "Input": map[string]interface{}{"n": float64(2)}},
+	}
+	if ResultsEqual(blocks, different) {
+		t.Error("expected nested difference to be detected")
+	}
+	if ResultsEqual(map[string]interface{}{"A": ""}, map[string]interface{}{"B": ""}) {
+		t.Error("expected differing keys to be detected")
+	}
+}
+
+// TestStopAtBlankLine verifies that a label can capture until the next blank line only.
+func TestStopAtBlankLine(t *testing.T) {
+	labels := []Label{
+		{Name: "Thought", StopAtBlankLine: true},
+		{Name: "Action"},
+	}
+	text := "Thought: I considered\nAction: as an idea\n\nstray text\nAction: run"
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse(text)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	if result["Thought"] != "I considered\nAction: as an idea" {
+		t.Errorf("unexpected Thought: %q", result["Thought"])
+	}
+	if result["Action"] != "run" {
+		t.Errorf("expected Action='run', got %q", result["Action"])
+	}
+
+	// A block start inside the paragraph does not split the block either
+	blockLabels := []Label{{Name: "Task", IsBlockStart: true}, {Name: "Thought", StopAtBlankLine: true}, {Name: "Result"}}
+	blockParser, err := NewParser(blockLabels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	text = "Task: a\nThought: x\nTask: inside the paragraph\n\nResult: r"
+	expected, _ := blockParser.Parse(text)
+	blocks, errs := blockParser.ParseBlocks(text)
+	if len(errs) > 0 || len(blocks) != 1 || !reflect.DeepEqual(blocks[0], expected) {
+		t.Errorf("expected a single block matching Parse %v, got %v %v", expected, blocks, errs)
+	}
+}
+
+// TestWarnAmbiguousMatches verifies warnings when several label patterns match one line.
+func TestWarnAmbiguousMatches(t *testing.T) {
+	// Both names compile to the same whitespace-tolerant pattern
+	labels := []Label{
+		{Name: "Action Input"},
+		{Name: "Action  Input"},
+		{Name: "Thought"},
+	}
+
+	parser, err := NewParser(labels, &ParserOptions{WarnAmbiguousMatches: true})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, errs := parser.ParseE("Thought: hmm\nAction Input: x")
+	if result["Action Input"] != "x" {
+		t.Errorf("expected first declared label to win, got %v", result)
+	}
+	if len(errs) != 1 || !errs[0].Warning || !errors.Is(errs[0], ErrAmbiguousMatch) {
+		t.Fatalf("expected one ambiguity warning, got %v", errs)
+	}
+	expected := "warning: line 2 matches multiple labels ('Action Input', 'Action  Input'); using 'Action Input'"
+	if errs[0].Message != expected {
+		t.Errorf("unexpected warning.\nGot: %q\nExpected: %q", errs[0].Message, expected)
+	}
+
+	// No warnings without the option
+	quiet, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	if _, errs := quiet.Parse("Action Input: x"); len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+// TestDedent verifies that common indentation is removed while relative indentation is kept.
+func TestDedent(t *testing.T) {
+	labels := []Label{
+		{Name: "Code", Dedent: true},
+		{Name: "Notes"},
+	}
+	text := "Code:\n\n    def f():\n        return 1\n\n    f()\nNotes:   indented note"
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse(text)
+	if len(errs) > 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	expected := "def f():\n    return 1\n\nf()"
+	if result["Code"] != expected {
+		t.Errorf("unexpected Code.\nGot: %q\nExpected: %q", result["Code"], expected)
+	}
+	if result["Notes"] != "indented note" {
+		t.Errorf("expected Notes to be trimmed normally, got %q", result["Notes"])
+	}
+}
+
+// TestParserCache verifies that the parser cache reuses and evicts compiled parsers.
+func TestParserCache(t *testing.T) {
+	cache := newParserCache(2)
+	labelsA := []Label{{Name: "A"}}
+	labelsC := []Label{{Name: "C"}}
+
+	first, err := cache.get(labelsA, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	again, _ := cache.get([]Label{{Name: "A"}}, nil)
+	if first != again {
+		t.Error("expected identical config to reuse the cached parser")
+	}
+	withOpts, _ := cache.get(labelsA, &ParserOptions{Separators: ":"})
+	if withOpts == first {
+		t.Error("expected different options to build a new parser")
+	}
+
+	// A is most recently used; adding C evicts the options variant
+	cache.get(labelsA, nil)
+	cache.get(labelsC, nil)
+	if cache.len() != 2 {
+		t.Errorf("expected 2 cached parsers, got %d", cache.len())
+	}
+	if p, _ := cache.get(labelsA, nil); p != first {
+		t.Error("expected recently used parser to survive eviction")
+	}
+
+	cache.setMaxSize(1)
+	if cache.len() != 1 {
+		t.Errorf("expected shrink to evict down to 1, got %d", cache.len())
+	}
+	cache.clear()
+	if cache.len() != 0 {
+		t.Errorf("expected empty cache after clear, got %d", cache.len())
+	}
+
+	// Construction errors are returned and not cached
+	if _, err := cache.get([]Label{{Name: "X", IsBlockStart: true}, {Name: "Y", IsBlockStart: true}}, nil); err == nil {
+		t.Error("expected construction error")
+	}
+	if cache.len() != 0 {
+		t.Errorf("expected failed construction not to be cached, got %d", cache.len())
+	}
+}
+
+// TestUnlessPresent verifies that a present label suppresses required checks of others.
+func TestUnlessPresent(t *testing.T) {
+	labels := []Label{
+		{Name: "Thought"},
+		{Name: "Action", Required: true, RequiredWith: []string{"Action Input"}, UnlessPresent: []string{"Final Answer"}},
+		{Name: "Action Input", UnlessPresent: []string{"final answer"}},
+		{Name: "Final Answer"},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	if _, errs := parser.Parse("Thought: done\nFinal Answer: 42"); len(errs) > 0 {
+		t.Errorf("unexpected errors when Final Answer is present: %v", errs)
+	}
+
+	_, errs := parser.Parse("Thought: thinking")
+	if len(errs) != 1 || errs[0] != "'Action' is required" {
+		t.Errorf("expected Action to be required without Final Answer, got %v", errs)
+	}
+}
+
+// TestValueStartingWithSeparator verifies that separator characters after the label's own
+// separator run are kept as part of the value.
+func TestValueStartingWithSeparator(t *testing.T) {
+	labels := []Label{
+		{Name: "Note"},
+		{Name: "Other"},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	cases := map[string]string{
+		"Note: - bullet":               "- bullet",
+		"Note - - bullet":              "- bullet",
+		"Note: = equation":             "= equation",
+		"Note:\n- bullet one\n- two":   "- bullet one\n- two",
+		"  Note: -- double dash start": "-- double dash start",
+	}
+	for text, want := range cases {
+		result, errs := parser.Parse(text)
+		if len(errs) > 0 {
+			t.Errorf("unexpected errors for %q: %v", text, errs)
+		}
+		if result["Note"] != want {
+			t.Errorf("Parse(%q): expected Note=%q, got %q", text, want, result["Note"])
+		}
+	}
+}
+
+// TestClassifyLine verifies per-line label classification details.
+func TestClassifyLine(t *testing.T) {
+	labels := []Label{
+		{Name: "Action Input"},
+		{Name: "Thought"},
+	}
+
+	parser, err := NewParser(labels, &ParserOptions{FuzzyLabelDistance: 1})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	cases := []struct {
+		line string
+		want LineMatch
+		ok   bool
+	}{
+		{"  ACTION   input :: {}", LineMatch{Canonical: "Action Input", AsWritten: "ACTION   input", Value: "{}", Separator: "::"}, true},
+		{"thought= hmm", LineMatch{Canonical: "Thought", AsWritten: "thought", Value: "hmm", Separator: "="}, true},
+		{"Thoght: typo", LineMatch{Canonical: "Thought", AsWritten: "Thoght", Value: "typo", Separator: ":"}, true},
+		{"just prose", LineMatch{}, false},
+	}
+	for _, c := range cases {
+		got, ok := parser.ClassifyLine(c.line)
+		if ok != c.ok || got != c.want {
+			t.Errorf("ClassifyLine(%q) = %+v, %v; want %+v, %v", c.line, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+// TestBlockFields verifies per-block-type label sets in ParseBlocks.
+func TestBlockFields(t *testing.T) {
+	labels := []Label{
+		{Name: "Tool Call", IsBlockStart: true, BlockFields: []string{"Arguments", "Body"}},
+		{Name: "Message", IsBlockStart: true, BlockFields: []string{"Author", "Body"}},
+		{Name: "Arguments", IsJSON: true},
+		{Name: "Author", Required: true},
+		{Name: "Body"},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	text := `Tool Call: search
+Arguments: {"q": "go"}
+Body: lookup
+Author: not a field here
+Message: greeting
+Author: Ann
+Body: hello`
+
+	blocks, errs := parser.ParseBlocks(text)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	expected := []map[string]interface{}{
+		{"Tool Call": "search", "Arguments": map[string]interface{}{"q": "go"}, "Body": "lookup\nAuthor: not a field here"},
+		{"Message": "greeting", "Author": "Ann", "Body": "hello"},
+	}
+	deepEqual(t, blocks, expected)
+
+	// Without BlockFields on every block start, only one is allowed.
+	labels[1].BlockFields = nil
+	if _, err := NewParser(labels, nil); err == nil || err.Error() != "only one block start label is allowed" {
+		t.Errorf("expected single block start error, got %v", err)
+	}
+
+	if _, err := NewParser([]Label{{Name: "A", IsBlockStart: true, BlockFields: []string{"B"}}}, nil); err == nil {
+		t.Error("expected error for unknown block field")
+	}
+}
+
+// TestAsSlice verifies single-vs-repeated value normalization helpers.
+func TestAsSlice(t *testing.T) {
+	parser, err := NewParser([]Label{{Name: "Step"}, {Name: "Note"}, {Name: "Tags", SplitOn: ","}}, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	result, _ := parser.Parse("Step: one\nStep: two\nNote: only\nTags: x, y")
+	deepEqual(t, AsSlice(result["Tags"]), []interface{}{"x", "y"})
+	deepEqual(t, AsStringSlice(result["Tags"]), []string{"x", "y"})
+	deepEqual(t, AsStringSlice(result["Step"]), []string{"one", "two"})
+	deepEqual(t, AsStringSlice(result["Note"]), []string{"only"})
+	deepEqual(t, AsSlice(result["Note"]), []interface{}{"only"})
+	if AsSlice(result["Missing"]) != nil || AsStringSlice(result["Missing"]) != nil {
+		t.Error("expected nil for absent label")
+	}
+	deepEqual(t, AsStringSlice([]interface{}{"a", float64(2)}), []string{"a", "2"})
+}
+
+// TestIncludeLabelInValue verifies that the matched line is kept verbatim.
+func TestIncludeLabelInValue(t *testing.T) {
+	labels := []Label{
+		{Name: "Audit", IncludeLabelInValue: true},
+		{Name: "Thought"},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	result, errs := parser.Parse("AUDIT -- approved by ops\nsecond line\nThought: ok")
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	expected := map[string]interface{}{
+		"Audit":   "AUDIT -- approved by ops\nsecond line",
+		"Thought": "ok",
+	}
+	deepEqual(t, result, expected)
+}
+
+// TestTabSeparator verifies tab-separated labels, both via TreatTabAsSeparator
+// and with a tab listed in Separators.
+func TestTabSeparator(t *testing.T) {
+	labels := []Label{
+		{Name: "Action"},
+		{Name: "Action Input"},
+	}
+	text := "Action\trun\nAction Input:\t{}"
+	expected := map[string]interface{}{
+		"Action":       "run",
+		"Action Input": "{}",
+	}
+
+	for _, opts := range []*ParserOptions{
+		{TreatTabAsSeparator: true},
+		{TreatTabAsSeparator: true, RequireSpaceAfterSeparator: true, FuzzyLabelDistance: 1},
+		{Separators: "\t:-"},
+	} {
+		parser, err := NewParser(labels, opts)
+		if err != nil {
+			t.Fatalf("failed to create parser: %v", err)
+		}
+		result, errs := parser.Parse(text)
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors with %+v: %v", opts, errs)
+		}
+		deepEqual(t, result, expected)
+
+		match, ok := parser.ClassifyLine("action\tgo")
+		if !ok || match.Separator != "\t" || match.Value != "go" {
+			t.Errorf("ClassifyLine with %+v = %+v, %v", opts, match, ok)
+		}
+	}
+
+	parser, _ := NewParser(labels, nil)
+	result, _ := parser.Parse(text)
+	if result["Action"] != "" {
+		t.Errorf("expected tab not to separate by default, got %v", result)
+	}
+}
+
+// TestParseFailFast verifies that parsing stops at the first error.
+func TestParseFailFast(t *testing.T) {
+	labels := []Label{
+		{Name: "Thought"},
+		{Name: "Config", IsJSON: true},
+		{Name: "Data", IsJSON: true},
+		{Name: "Answer", Required: true},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	result, err := parser.ParseFailFast("Thought: hmm\nConfig: {bad\nData: {bad too")
+	if !errors.Is(err, ErrJSON) {
+		t.Fatalf("expected ErrJSON, got %v", err)
+	}
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Label != "Config" {
+		t.Errorf("expected first error to concern Config, got %v", err)
+	}
+	if _, ok := result["Data"]; ok {
+		t.Errorf("expected processing to stop before Data, got %v", result)
+	}
+	if result["Thought"] != "hmm" {
+		t.Errorf("expected partial result to keep Thought, got %v", result)
+	}
+
+	_, err = parser.ParseFailFast("Thought: hmm")
+	if !errors.Is(err, ErrRequired) {
+		t.Errorf("expected ErrRequired, got %v", err)
+	}
+
+	result, err = parser.ParseFailFast("Answer: 42")
+	if err != nil || result["Answer"] != "42" {
+		t.Errorf("expected success, got %v, %v", result, err)
+	}
+}
+
+// TestBlockSeparatorPattern verifies divider lines as block boundaries.
+func TestBlockSeparatorPattern(t *testing.T) {
+	labels := []Label{
+		{Name: "Name"},
+		{Name: "Role"},
+	}
+
+	parser, err := NewParser(labels, &ParserOptions{BlockSeparatorPattern: `^-{3,}$`})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	text := "Name: Ann\nRole: dev\n----\nName: Bo\n---\n\n---\nRole: ops\n---"
+	blocks, errs := parser.ParseBlocks(text)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	expected := []map[string]interface{}{
+		{"Name": "Ann", "Role": "dev"},
+		{"Name": "Bo", "Role": ""},
+		{"Name": "", "Role": "ops"},
+	}
+	deepEqual(t, blocks, expected)
+
+	// Combined with a block start label, both split blocks.
+	labels[0].IsBlockStart = true
+	parser, err = NewParser(labels, &ParserOptions{BlockSeparatorPattern: `^=+$`})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	blocks, _ = parser.ParseBlocks("ignored\nName: Ann\nName: Bo\n===\nRole: ops")
+	expected = []map[string]interface{}{
+		{"Name": "Ann", "Role": ""},
+		{"Name": "Bo", "Role": ""},
+		{"Name": "", "Role": "ops"},
+	}
+	deepEqual(t, blocks, expected)
+
+	if _, err := NewParser(labels, &ParserOptions{BlockSeparatorPattern: `(`}); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+// TestParseErrorDetail verifies the observed-state detail on validation errors.
+func TestParseErrorDetail(t *testing.T) {
+	labels := []Label{
+		{Name: "Action"},
+		{Name: "Action Input", RequiredWith: []string{"Action"}},
+		{Name: "Answer", Required: true},
+		{Name: "Config", IsJSON: true},
+		{Name: "Tool", RequiredWith: []string{"Config.id"}},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	details := func(text string) map[ErrorCode]string {
+		_, errs := parser.ParseE(text)
+		got := make(map[ErrorCode]string)
+		for _, e := range errs {
+			got[e.Code] += e.Detail
+		}
+		return got
+	}
+
+	got := details("Action:\nAction Input: x\nAnswer: y")
+	if got[CodeRequiredWith] != "'Action' appeared but its value is empty" {
+		t.Errorf("unexpected detail: %q", got[CodeRequiredWith])
+	}
+
+	got = details("Action Input: x")
+	if got[CodeRequiredWith] != "'Action' did not appear in the text" {
+		t.Errorf("unexpected detail: %q", got[CodeRequiredWith])
+	}
+	if got[CodeRequired] != "'Answer' did not appear in the text" {
+		t.Errorf("unexpected detail: %q", got[CodeRequired])
+	}
+
+	got = details("Answer: y\nConfig: {}\nTool: t")
+	if got[CodeRequiredWith] != "'Config' is present but its value has no key 'id'" {
+		t.Errorf("unexpected detail: %q", got[CodeRequiredWith])
+	}
+}
+
+// TestParseUnit verifies splitting numeric values from trailing units.
+func TestParseUnit(t *testing.T) {
+	labels := []Label{
+		{Name: "Temperature", ParseUnit: true},
+		{Name: "Size", ParseUnit: true},
+		{Name: "Count", ParseUnit: true},
+		{Name: "Speed", ParseUnit: true},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	result, errs := parser.ParseE("Temperature: 22C\nSize: 5.5 MB\nCount: -3\nSpeed: fast")
+	expected := map[string]interface{}{
+		"Temperature": map[string]interface{}{"value": 22.0, "unit": "C"},
+		"Size":        map[string]interface{}{"value": 5.5, "unit": "MB"},
+		"Count":       map[string]interface{}{"value": -3.0, "unit": ""},
+		"Speed":       "fast",
+	}
+	deepEqual(t, result, expected)
+
+	if len(errs) != 1 || !errs[0].Warning || !errors.Is(errs[0], ErrUnitParse) || errs[0].Label != "Speed" {
+		t.Errorf("expected a single unit warning for Speed, got %v", errs)
+	}
+}
+
+// TestDedupeValues verifies removal of identical repeated values.
+func TestDedupeValues(t *testing.T) {
+	labels := []Label{
+		{Name: "Step", DedupeValues: true},
+		{Name: "Tag", Repeatable: true, DedupeValues: true},
+		{Name: "Args", IsJSON: true, DedupeValues: true},
+	}
+
+	parser, err := NewParser(labels, &ParserOptions{DuplicatePolicy: DuplicateKeepFirst})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	text := "Step: go\nTag: a\nStep: go\nTag: b\nTag: a\nArgs: {\"x\": 1}\nArgs: {\"x\": 1}"
+	result, errs := parser.Parse(text)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	expected := map[string]interface{}{
+		"Step": "go",
+		"Tag":  []interface{}{"a", "b"},
+		"Args": map[string]interface{}{"x": 1.0},
+	}
+	deepEqual(t, result, expected)
+}
+
+// TestCoerce verifies post-parse type coercion from a shape map.
+func TestCoerce(t *testing.T) {
+	labels := []Label{
+		{Name: "Count"},
+		{Name: "Ratio"},
+		{Name: "Done"},
+		{Name: "Meta"},
+		{Name: "Config", IsJSON: true},
+		{Name: "Step", Repeatable: true},
+		{Name: "Bad"},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	result, _ := parser.Parse("Count: 3\nRatio: 0.5\nDone: True\nMeta: {\"a\": [1]}\nConfig: {\"k\": 2}\nStep: 1\nStep: 2\nBad: x")
+	shape := map[string]string{
+		"Count":  "int",
+		"Ratio":  "float",
+		"Done":   "bool",
+		"Meta":   "json",
+		"Config": "string",
+		"Step":   "int",
+		"Bad":    "int",
+		"Absent": "int",
+	}
+	coerced, errs := Coerce(result, shape)
+	expected := map[string]interface{}{
+		"Count":  3,
+		"Ratio":  0.5,
+		"Done":   true,
+		"Meta":   map[string]interface{}{"a": []interface{}{1.0}},
+		"Config": `{"k":2}`,
+		"Step":   []interface{}{1, 2},
+		"Bad":    "x",
+	}
+	deepEqual(t, coerced, expected)
+	if len(errs) != 1 || !strings.HasPrefix(errs[0], "'Bad' cannot be converted to int") {
+		t.Errorf("expected one error for Bad, got %v", errs)
+	}
+	if result["Count"] != "3" {
+		t.Error("expected Coerce not to modify its input")
+	}
+}
+
+// TestEscapeFence verifies that \{{ ... }} protects label-looking text.
+func TestEscapeFence(t *testing.T) {
+	labels := []Label{
+		{Name: "Thought"},
+		{Name: "Action"},
+		{Name: "Args", IsJSON: true},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	text := `Thought: I will write \{{Action: search}} next, then
+\{{
+Action: lookup
+}} done
+Action: search
+Args: {"a": {"b": 1}}`
+
+	result, errs := parser.Parse(text)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	expected := map[string]interface{}{
+		"Thought": "I will write Action: search next, then\n\nAction: lookup\n done",
+		"Action":  "search",
+		"Args":    map[string]interface{}{"a": map[string]interface{}{"b": 1.0}},
+	}
+	deepEqual(t, result, expected)
+
+	blockParser, err := NewParser([]Label{{Name: "Task", IsBlockStart: true}, {Name: "Note"}}, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	blocks, _ := blockParser.ParseBlocks("Task: one\nNote: \\{{\nTask: not a block\n}}")
+	if len(blocks) != 1 || blocks[0]["Note"] != "Task: not a block" {
+		t.Errorf("expected fenced block start to be ignored, got %v", blocks)
+	}
+}
+
+// TestDiscoverLabels verifies label suggestions from sample output.
+func TestDiscoverLabels(t *testing.T) {
+	text := `Thought: I should search
+Action: search
+Action Input: {"q": "go"}
+  nested: not a label
+see http://example.com for more
+THOUGHT: again
+Result:`
+
+	expected := []Label{
+		{Name: "Thought"},
+		{Name: "Action"},
+		{Name: "Action Input", IsJSON: true},
+		{Name: "Result"},
+	}
+	if got := DiscoverLabels(text); !reflect.DeepEqual(got, expected) {
+		t.Errorf("DiscoverLabels() = %+v, want %+v", got, expected)
+	}
+}
+
+// TestParseWithSpans verifies per-field source line spans.
+func TestParseWithSpans(t *testing.T) {
+	labels := []Label{
+		{Name: "Thought"},
+		{Name: "Action", Repeatable: true},
+		{Name: "Answer"},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	text := "Thought: first\nmore\n\nAction: one\nAction: two\n\nAnswer:"
+	result, spans, errs := parser.ParseWithSpans(text)
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if result["Thought"] != "first\nmore" {
+		t.Errorf("unexpected result: %v", result)
+	}
+	expected := map[string][2]int{
+		"Thought": {1, 2},
+		"Action":  {5, 5},
+		"Answer":  {7, 7},
+	}
+	if !reflect.DeepEqual(spans, expected) {
+		t.Errorf("spans = %v, want %v", spans, expected)
+	}
+}
+
+// TestNormalizeCaseAndEnum verifies case folding and enum validation.
+func TestNormalizeCaseAndEnum(t *testing.T) {
+	labels := []Label{
+		{Name: "Status", Enum: []string{"Active", "Inactive"}},
+		{Name: "Level", NormalizeCase: "upper", Enum: []string{"low", "high"}},
+		{Name: "Title", NormalizeCase: "title"},
+		{Name: "Mode", Enum: []string{"fast"}},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	result, errs := parser.ParseE("Status: ACTIVE\nLevel: High\nTitle: the GO way\nMode: slow")
+	expected := map[string]interface{}{
+		"Status": "Active",
+		"Level":  "HIGH",
+		"Title":  "The Go Way",
+		"Mode":   "slow",
+	}
+	deepEqual(t, result, expected)
+	if len(errs) != 1 || !errors.Is(errs[0], ErrEnum) || errs[0].Message != "'Mode' must be one of 'fast', got 'slow'" {
+		t.Errorf("expected one enum error for Mode, got %v", errs)
+	}
+
+	if _, err := NewParser([]Label{{Name: "X", NormalizeCase: "snake"}}, nil); err == nil {
+		t.Error("expected error for unknown normalizeCase")
+	}
+}
+
+// TestValueOnNextLine verifies values placed on the line after their label.
+func TestValueOnNextLine(t *testing.T) {
+	labels := []Label{
+		{Name: "Thought"},
+		{Name: "Action", ValueOnNextLine: true},
+		{Name: "Answer"},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	cases := []struct {
+		text     string
+		expected map[string]interface{}
+	}{
+		{"Thought:\n  pondering\nAnswer: 1", map[string]interface{}{"Thought": "pondering", "Action": "", "Answer": "1"}},
+		{"Action:\n\nThought: as a value\nAnswer: 2", map[string]interface{}{"Thought": "", "Action": "Thought: as a value", "Answer": "2"}},
+		{"Action: run\nThought: real", map[string]interface{}{"Thought": "real", "Action": "run", "Answer": ""}},
+		{"Thought:\nAnswer: 3", map[string]interface{}{"Thought": "", "Action": "", "Answer": "3"}},
+	}
+	for _, c := range cases {
+		result, errs := parser.Parse(c.text)
+		if len(errs) != 0 {
+			t.Fatalf("unexpected errors for %q: %v", c.text, errs)
+		}
+		deepEqual(t, result, c.expected)
+	}
+
+	labels = append(labels, Label{Name: "Step", IsBlockStart: true})
+	blockParser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+	blocks, _ := blockParser.ParseBlocks("Step: 1\nAction:\nStep: inner\nStep: 2")
+	if len(blocks) != 2 || blocks[0]["Action"] != "Step: inner" {
+		t.Errorf("expected next-line value not to start a block, got %v", blocks)
+	}
+}
+
+// TestSeparatorStats verifies separator usage counting across inputs.
+func TestSeparatorStats(t *testing.T) {
+	parser, err := NewParser([]Label{{Name: "Thought"}, {Name: "Action"}}, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	stats := SeparatorStats(parser, []string{
+		"Thought: a\nAction - b",
+		"Thought = c\nAction: d\nnot a label: e",
+	})
+	expected := map[string]int{":": 2, "-": 1, "=": 1}
+	if !reflect.DeepEqual(stats, expected) {
+		t.Errorf("SeparatorStats() = %v, want %v", stats, expected)
+	}
+}
+
+// TestDisallowInterleavedFields verifies errors for fields resumed after another field.
+func TestDisallowInterleavedFields(t *testing.T) {
+	labels := []Label{
+		{Name: "Thought"},
+		{Name: "Action"},
+		{Name: "Note", Repeatable: true},
+	}
+
+	parser, err := NewParser(labels, &ParserOptions{DisallowInterleavedFields: true})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	// Each error comes with the warning for the repeated Thought
+	_, errs := parser.ParseE("Thought: a\nThought: b\nNote: x\nAction: c\nNote: y\nThought: d")
+	if len(errs) != 2 || !errors.Is(errs[0], ErrInterleavedField) || errs[0].Message != "'Thought' reappears on line 6 after another field" || !errs[1].Warning {
+		t.Errorf("expected one interleaving error for Thought, got %v", errs)
+	}
+
+	parser, _ = NewParser(labels, nil)
+	if _, errs := parser.ParseE("Thought: a\nAction: c\nThought: d"); len(errs) != 1 || !errs[0].Warning {
+		t.Errorf("expected interleaving to be allowed by default, got %v", errs)
+	}
+}
+
+// TestFlagOnly verifies presence-only boolean labels.
+func TestFlagOnly(t *testing.T) {
+	labels := []Label{
+		{Name: "Verified", FlagOnly: true},
+		{Name: "Needs Review", FlagOnly: true},
+		{Name: "Approved", FlagOnly: true, Required: true},
+		{Name: "Notes"},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	result, errs := parser.Parse("Notes: checked\nverified\nApproved: yes indeed\nextra text")
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	expected := map[string]interface{}{
+		"Verified":     true,
+		"Needs Review": false,
+		"Approved":     true,
+		"Notes":        "checked",
+	}
+	deepEqual(t, result, expected)
+
+	if _, errs := parser.Parse("Verified:"); len(errs) != 1 || errs[0] != "'Approved' is required" {
+		t.Errorf("expected required error for absent flag, got %v", errs)
+	}
+}
+
+// TestMaxErrors verifies capping of the error list.
+func TestMaxErrors(t *testing.T) {
+	labels := []Label{
+		{Name: "Task", IsBlockStart: true},
+		{Name: "Data", IsJSON: true, Repeatable: true},
+	}
+
+	parser, err := NewParser(labels, &ParserOptions{MaxErrors: 2})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	text := "Task: 1\nData: [bad]\nTask: 2\nData: [bad]\nTask: 3\nData: [bad]\nTask: 4\nData: [bad]"
+	_, errs := parser.ParseBlocks(text)
+	if len(errs) != 3 || errs[2] != "...and 2 more errors" {
+		t.Errorf("expected 2 errors plus summary, got %v", errs)
+	}
+
+	_, parseErrs := parser.ParseE("Data: [bad]\nData: [bad]\nData: [bad]")
+	if len(parseErrs) != 3 || !errors.Is(parseErrs[2], ErrTooManyErrors) || parseErrs[2].Message != "...and 1 more error" {
+		t.Errorf("expected 2 errors plus summary, got %v", parseErrs)
+	}
+
+	parser, _ = NewParser(labels, nil)
+	if _, errs := parser.ParseBlocks(text); len(errs) != 4 {
+		t.Errorf("expected all 4 errors without a limit, got %v", errs)
+	}
+}
+
+// TestParserConcurrentUse verifies that a shared Parser can be used from
+// several goroutines at once.
+func TestParserConcurrentUse(t *testing.T) {
+	parser, err := NewParser([]Label{{Name: "Thought"}, {Name: "Args", IsJSON: true}}, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				id := strconv.Itoa(g*1000 + i)
+				result, errs := parser.Parse("Thought: " + id + "\nline two\nArgs: {\"id\": " + id + "}")
+				if len(errs) != 0 || result["Thought"] != id+"\nline two" {
+					t.Errorf("unexpected result %v, %v", result, errs)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+// TestStripLabelPrefixRunes verifies matching labels decorated with icons.
+func TestStripLabelPrefixRunes(t *testing.T) {
+	labels := []Label{
+		{Name: "Thought"},
+		{Name: "Action"},
+	}
+
+	parser, err := NewParser(labels, &ParserOptions{StripLabelPrefixRunes: "🧠⚙️*"})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	result, errs := parser.Parse("🧠 Thought: hmm\n⚙️ **Action: run")
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	deepEqual(t, result, map[string]interface{}{"Thought": "hmm", "Action": "run"})
+
+	if match, ok := parser.ClassifyLine("🧠Thought: x"); !ok || match.AsWritten != "Thought" {
+		t.Errorf("unexpected ClassifyLine result: %+v, %v", match, ok)
+	}
+
+	parser, _ = NewParser(labels, nil)
+	result, _ = parser.Parse("🧠 Thought: hmm")
+	if result["Thought"] != "" {
+		t.Errorf("expected no match without the option, got %v", result)
+	}
+}
+
+// TestParseBlocksMatchesParseForSingleBlock verifies that ParseBlocks on a
+// single-block input yields exactly what Parse returns, errors included.
+func TestParseBlocksMatchesParseForSingleBlock(t *testing.T) {
+	labels := []Label{
+		{Name: "Task", IsBlockStart: true},
+		{Name: "Data", IsJSON: true},
+		{Name: "Note", Dedent: true},
+		{Name: "Code", StopAtBlankLine: true},
+		{Name: "Summary"},
+		{Name: "Summary Line"},
+	}
+
+	parser, err := NewParser(labels, &ParserOptions{WarnAmbiguousMatches: true, DisallowInterleavedFields: true})
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	inputs := []string{
+		"Task: a\nData: {\"x\": 1}\nNote:\n    indented\n      more",
+		"Task: a\r\nNote: b  \r\n\r\n",
+		"```\nTask: a\nNote: b\n```",
+		"Task: a\nData: `{\"x\": 1}`",
+		"Task: a\nCode: x\n\nNote: y",
+		"   Task: a\nNote:   b",
+		"Task:\nNote: \\{{\nTask: x\n}}",
+		"Task: a\nData: {bad\nSummary Line: x\nNote: n\nSummary Line: y",
+	}
+	for _, text := range inputs {
+		result, errs := parser.Parse(text)
+		blocks, blockErrs := parser.ParseBlocks(text)
+		if len(blocks) != 1 {
+			t.Errorf("%q: expected one block, got %v", text, blocks)
+			continue
+		}
+		deepEqual(t, blocks[0], result)
+		deepEqual(t, blockErrs, errs)
+	}
+
+	// Line numbers in block errors refer to the whole input.
+	_, blockErrs := parser.ParseBlocks("Task: a\nNote: x\nTask: b\nNote: y\nSummary: s\nNote: z")
+	deepEqual(t, blockErrs, []string{"'Note' reappears on line 6 after another field"})
+}
+
+// TestComputedLabel verifies template-derived fields.
+func TestComputedLabel(t *testing.T) {
+	labels := []Label{
+		{Name: "Action"},
+		{Name: "Target"},
+		{Name: "Args", IsJSON: true},
+		{Name: "Summary", Computed: "{Action} on {target} with {Args}{Missing}"},
+		{Name: "Headline", Computed: "> {Summary}"},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	result, errs := parser.Parse("Action: deploy\nArgs: {\"n\": 2}\nTarget: prod\nSummary: not a label")
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	expected := map[string]interface{}{
+		"Action":   "deploy",
+		"Target":   "prod\nSummary: not a label",
+		"Args":     map[string]interface{}{"n": 2.0},
+		"Summary":  "deploy on prod\nSummary: not a label with {\"n\":2}",
+		"Headline": "> deploy on prod\nSummary: not a label with {\"n\":2}",
+	}
+	deepEqual(t, result, expected)
+}
+
+// TestSpacedAndDoubledSeparators verifies "Name : value" and doubled separators.
+func TestSpacedAndDoubledSeparators(t *testing.T) {
+	parser, err := NewParser([]Label{{Name: "Action"}, {Name: "Action Input"}}, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	cases := map[string]string{
+		"Action : run":      "run",
+		"Action :: run":     "run",
+		"Action: : run":     "run",
+		"Action :  ::  run": "::  run",
+		"Action: - item":    "- item",
+		"Action: :)":        ":)",
+		"Action:\t: run":    "run",
+	}
+	for line, want := range cases {
+		result, errs := parser.Parse(line)
+		if len(errs) != 0 || result["Action"] != want {
+			t.Errorf("Parse(%q) = %q, %v; want %q", line, result["Action"], errs, want)
+		}
+	}
+
+	result, _ := parser.Parse("Action Input : {}")
+	if result["Action Input"] != "{}" || result["Action"] != "" {
+		t.Errorf("unexpected result for spaced multi-word label: %v", result)
+	}
+}
+
+// TestParseToOrderedJSON verifies label-ordered JSON output.
+func TestParseToOrderedJSON(t *testing.T) {
+	labels := []Label{
+		{Name: "Zeta"},
+		{Name: "Alpha", IsJSON: true},
+		{Name: "Mid"},
+	}
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("failed to create parser: %v", err)
+	}
+
+	data, errs := parser.ParseToOrderedJSON("Mid: m\nAlpha: {\"b\": 1, \"a\": [true]}\nZeta: \"quoted\"")
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	want := `{"Zeta":"\"quoted\"","Alpha":{"a":[true],"b":1},"Mid":"m"}`
+	if string(data) != want {
+		t.Errorf("ParseToOrderedJSON() = %s, want %s", data, want)
+	}
+}
+
+// TestJoinErrors verifies that error strings are joined into a single error.
+func TestJoinErrors(t *testing.T) {
+	if err := JoinErrors(nil); err != nil {
+		t.Errorf("Expected nil for no errors, got %v", err)
+	}
+	err := JoinErrors([]string{"first", "second"})
+	if err == nil || err.Error() != "first\nsecond" {
+		t.Fatalf("Expected joined message, got %v", err)
+	}
+	unwrapped := err.(interface{ Unwrap() []error }).Unwrap()
+	if len(unwrapped) != 2 || unwrapped[1].Error() != "second" {
+		t.Errorf("Expected two unwrapped errors, got %v", unwrapped)
+	}
+
+	parser, _ := NewParser([]Label{{Name: "Thought", Required: true}, {Name: "Action"}}, nil)
+	result, err := parser.ParseErr("Action: go")
+	if err == nil || !strings.Contains(err.Error(), "Thought") {
+		t.Errorf("Expected required error, got %v", err)
+	}
+	if result["Action"] != "go" {
+		t.Errorf("Expected partial result, got %v", result)
+	}
+	if _, err := parser.ParseErr("Thought: hmm"); err != nil {
+		t.Errorf("Expected nil error, got %v", err)
+	}
+}
+
+// TestParagraphsAsArray verifies that a value is split into paragraphs at blank lines.
+func TestParagraphsAsArray(t *testing.T) {
+	parser, err := NewParser([]Label{{Name: "Reasoning Steps", ParagraphsAsArray: true}, {Name: "Answer"}}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	text := "Reasoning Steps: First, read the question.\nIt asks for a sum.\n\n\nSecond, add the numbers.\n\n  Third, check the result.  \nAnswer: 4"
+	result, errs := parser.Parse(text)
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	expected := []string{"First, read the question.\nIt asks for a sum.", "Second, add the numbers.", "Third, check the result."}
+	if !reflect.DeepEqual(result["Reasoning Steps"], expected) {
+		t.Errorf("Expected %q, got %#v", expected, result["Reasoning Steps"])
+	}
+	if result["Answer"] != "4" {
+		t.Errorf("Expected Answer '4', got %v", result["Answer"])
+	}
+}
+
+// TestGlobalAliases verifies that a shared alias table maps alternative names
+// to declared labels.
+func TestGlobalAliases(t *testing.T) {
+	aliases := map[string]string{
+		"Final Response": "Final Answer",
+		"thinking":       "Thought",
+		"Answer":         "Final Answer", // Shadowed by the declared label
+		"Tool":           "Action",       // Not declared in this parser
+	}
+	labels := []Label{{Name: "Thought"}, {Name: "Final Answer"}, {Name: "Answer"}}
+	parser, err := NewParser(labels, &ParserOptions{GlobalAliases: aliases})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	result, _ := parser.Parse("Thinking: hmm\nTool: search\nFinal response: 42\nAnswer: yes")
+	if result["Thought"] != "hmm\nTool: search" {
+		t.Errorf("Expected aliased Thought, got %q", result["Thought"])
+	}
+	if result["Final Answer"] != "42" {
+		t.Errorf("Expected aliased Final Answer, got %q", result["Final Answer"])
+	}
+	if result["Answer"] != "yes" {
+		t.Errorf("Expected declared label to win over alias, got %q", result["Answer"])
+	}
+
+	plain, _ := NewParser(labels, nil)
+	result, _ = plain.ParseWith("Final Response: 42", &ParserOptions{GlobalAliases: aliases})
+	if result["Final Answer"] != "42" {
+		t.Errorf("Expected alias applied via ParseWith, got %v", result)
+	}
+
+	// Aliases with regex syntax match literally
+	literal, err := NewParser(labels, &ParserOptions{Separators: ":", GlobalAliases: map[string]string{"C++": "Final Answer", "Cost (USD": "Thought"}})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	result, _ = literal.Parse("Cost (USD: 3\nC++: 42\nCCC: no")
+	if result["Thought"] != "3" || result["Final Answer"] != "42\nCCC: no" {
+		t.Errorf("Expected literal alias matches, got %v", result)
+	}
+}
+
+// TestParseBlocksReader verifies that streamed blocks match ParseBlocks, even
+// when code fences span many small reads.
+func TestParseBlocksReader(t *testing.T) {
+	labels := []Label{{Name: "Task", IsBlockStart: true}, {Name: "Input", IsJSON: true}, {Name: "Notes"}}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	text := "\n\nintro\nTask: one\nInput: ```json\n{\"a\": 1,\n \"b\": 2}\n```\nNotes: use `x`\n\nTask: two\nInput: {bad\nTask: three\nNotes: last\n"
+	expected, expectedErrs := parser.ParseBlocks(text)
+
+	var blocks []map[string]interface{}
+	var errs []string
+	err = parser.ParseBlocksReader(iotest.OneByteReader(strings.NewReader(text)), func(i int, block map[string]interface{}, blockErrs []string) error {
+		if i != len(blocks) {
+			t.Errorf("Expected block index %d, got %d", len(blocks), i)
+		}
+		blocks = append(blocks, block)
+		errs = append(errs, blockErrs...)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !ResultsEqual(blocks, expected) {
+		t.Errorf("Expected %v, got %v", expected, blocks)
+	}
+	if !reflect.DeepEqual(errs, expectedErrs) {
+		t.Errorf("Expected errors %v, got %v", expectedErrs, errs)
+	}
+
+	stop := errors.New("stop")
+	calls := 0
+	err = parser.ParseBlocksReader(strings.NewReader(text), func(int, map[string]interface{}, []string) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("Expected callback error after one call, got %v after %d", err, calls)
+	}
+
+	noStart, _ := NewParser([]Label{{Name: "Notes"}}, nil)
+	err = noStart.ParseBlocksReader(strings.NewReader(text), func(int, map[string]interface{}, []string) error { return nil })
+	if !errors.Is(err, ErrNoBlockStart) {
+		t.Errorf("Expected ErrNoBlockStart, got %v", err)
+	}
+}
+
+// TestBlockStartAsKey verifies keying blocks by their block start value.
+func TestBlockStartAsKey(t *testing.T) {
+	labels := []Label{
+		{Name: "Task", IsBlockStart: true, BlockFields: []string{"Status"}},
+		{Name: "Bug", IsBlockStart: true, BlockFields: []string{"Status"}},
+		{Name: "Status"},
+	}
+	parser, err := NewParser(labels, &ParserOptions{BlockStartAsKey: true, BlockSeparatorPattern: "^-{3,}$"})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	text := "Task: write docs\nStatus: done\nBug: crash on start\nStatus: open\n---\nStatus: orphan"
+	indexed, errs := parser.ParseBlocksByKey(text, "")
+	expected := map[string]map[string]interface{}{
+		"write docs":     {"Status": "done"},
+		"crash on start": {"Status": "open"},
+	}
+	if !reflect.DeepEqual(indexed, expected) {
+		t.Errorf("Expected %v, got %v", expected, indexed)
+	}
+	if len(errs) != 1 || errs[0] != "block 3 has no block start value" {
+		t.Errorf("Expected missing key error for the divider block, got %v", errs)
+	}
+
+	indexed, _ = parser.ParseBlocksByKey(text, "Task")
+	if indexed["write docs"]["Task"] != "write docs" {
+		t.Errorf("Expected explicit keyField to keep the field, got %v", indexed)
+	}
+}
+
+// TestRecommendedLabels verifies that missing recommended labels produce
+// warnings, reported separately by ParseWithWarnings.
+func TestRecommendedLabels(t *testing.T) {
+	labels := []Label{{Name: "Thought", Recommended: true}, {Name: "Action", Required: true}, {Name: "Confidence", Recommended: true}}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	result, errs, warnings := parser.ParseWithWarnings("Action: search\nConfidence:")
+	if len(errs) != 0 {
+		t.Errorf("Expected no errors, got %v", errs)
+	}
+	expected := []string{"warning: 'Thought' is recommended but missing", "warning: 'Confidence' is recommended but missing"}
+	if !reflect.DeepEqual(warnings, expected) {
+		t.Errorf("Expected warnings %v, got %v", expected, warnings)
+	}
+	if result["Action"] != "search" {
+		t.Errorf("Expected Action 'search', got %v", result["Action"])
+	}
+
+	_, structured := parser.ParseE("Thought: hmm")
+	if len(structured) != 2 || !errors.Is(structured[0], ErrRequired) || !errors.Is(structured[1], ErrRecommended) || !structured[1].Warning {
+		t.Errorf("Expected required error and recommended warning, got %v", structured)
+	}
+}
+
+// TestAgentMode verifies the ReAct constraints enabled by AgentMode.
+func TestAgentMode(t *testing.T) {
+	labels := []Label{{Name: "Thought"}, {Name: "Action"}, {Name: "Action Input", IsJSON: true}, {Name: "Final Answer"}}
+	parser, err := NewParser(labels, &ParserOptions{AgentMode: true})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	cases := []struct {
+		text     string
+		expected []string
+	}{
+		{"Thought: hmm\nAction: search\nAction Input: {\"q\": \"go\"}", nil},
+		{"Thought: done\nFinal Answer: 42", nil},
+		{"Action: search", []string{"'Action' requires 'Action Input'"}},
+		{"Action: search\nAction Input: {}\nFinal Answer: 42", []string{"'Action' and 'Final Answer' cannot both appear"}},
+	}
+	for _, tc := range cases {
+		_, errs := parser.Parse(tc.text)
+		if len(errs) != len(tc.expected) || (len(errs) > 0 && !reflect.DeepEqual(errs, tc.expected)) {
+			t.Errorf("Parse(%q): expected %v, got %v", tc.text, tc.expected, errs)
+		}
+	}
+
+	_, errs := parser.ParseE("Action: search\nFinal Answer: 42")
+	if len(errs) != 2 || !errors.Is(errs[1], ErrConflict) {
+		t.Errorf("Expected requirement and conflict errors, got %v", errs)
+	}
+	if _, plain := parser.ParseWith("Action: search", nil); len(plain) != 1 {
+		t.Errorf("Expected AgentMode to persist through ParseWith, got %v", plain)
+	}
+}
+
+// TestMinMaxCount verifies occurrence bounds on a label.
+func TestMinMaxCount(t *testing.T) {
+	parser, err := NewParser([]Label{{Name: "Step", Repeatable: true, MinCount: 1, MaxCount: 3}, {Name: "Answer"}}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	cases := []struct {
+		text     string
+		expected []string
+	}{
+		{"Step: a\nStep: b\nAnswer: c", nil},
+		{"Answer: c", []string{"'Step' requires at least 1 occurrence, got 0"}},
+		{"Step: a\nStep: b\nStep: c\nStep: d", []string{"'Step' allows at most 3 occurrences, got 4"}},
+	}
+	for _, tc := range cases {
+		_, errs := parser.Parse(tc.text)
+		if len(errs) != len(tc.expected) || (len(errs) > 0 && !reflect.DeepEqual(errs, tc.expected)) {
+			t.Errorf("Parse(%q): expected %v, got %v", tc.text, tc.expected, errs)
+		}
+	}
+
+	if _, err := NewParser([]Label{{Name: "Step", MinCount: 4, MaxCount: 2}}, nil); err == nil {
+		t.Error("Expected error for minCount above maxCount")
+	}
+}
+
+// TestSplitOn verifies splitting a value into a list on a delimiter.
+func TestSplitOn(t *testing.T) {
+	labels := []Label{{Name: "Tags", SplitOn: ","}, {Name: "Priority", SplitOn: "|", Enum: []string{"High", "Low"}}}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse("Tags: a, b,, c ,\nPriority: high | urgent")
+	if !reflect.DeepEqual(result["Tags"], []string{"a", "b", "c"}) {
+		t.Errorf("Expected split tags, got %#v", result["Tags"])
+	}
+	if !reflect.DeepEqual(result["Priority"], []string{"High", "urgent"}) {
+		t.Errorf("Expected enum casing per element, got %#v", result["Priority"])
+	}
+	if len(errs) != 1 || !strings.Contains(errs[0], "got 'urgent'") {
+		t.Errorf("Expected enum error for 'urgent', got %v", errs)
+	}
+}
+
+// TestPatterns verifies that the generated label patterns are exposed.
+func TestPatterns(t *testing.T) {
+	labels := []Label{{Name: "Action Input"}, {Name: "Summary", Computed: "{Action Input}"}}
+	parser, err := NewParser(labels, &ParserOptions{Separators: ":-", GlobalAliases: map[string]string{"Input": "Action Input"}})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	patterns := parser.Patterns()
+	expected := map[string]string{"Action Input": `(?i)^\s*(action\s+input)\s*([:-]+)\s*`}
+	if !reflect.DeepEqual(patterns, expected) {
+		t.Errorf("Expected %v, got %v", expected, patterns)
+	}
+}
+
+// TestFlexibleWordSeparators verifies that underscores and hyphens may
+// separate the words of a label.
+func TestFlexibleWordSeparators(t *testing.T) {
+	labels := []Label{{Name: "Action"}, {Name: "Action Input"}}
+	parser, err := NewParser(labels, &ParserOptions{FlexibleWordSeparators: true})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	for _, line := range []string{"Action_Input: x", "Action-Input: x", "Action Input: x", "action _ input: x"} {
+		result, _ := parser.Parse("Action: run\n" + line)
+		if result["Action Input"] != "x" || result["Action"] != "run" {
+			t.Errorf("Parse(%q): expected Action Input 'x', got %v", line, result)
+		}
+	}
+
+	strict, _ := NewParser(labels, nil)
+	result, _ := strict.Parse("Action: run\nAction_Input: x")
+	if result["Action Input"] != "" {
+		t.Errorf("Expected underscores not to match by default, got %v", result)
+	}
+}
+
+// FuzzParseToOrderedJSONRoundTrip verifies that the ordered JSON encoding of a
+// parse result decodes to the same value as encoding/json produces for it.
+func FuzzParseToOrderedJSONRoundTrip(f *testing.F) {
+	f.Add("Thought: hmm\nAction: search\nAction Input: {\"q\": [1, 2]}")
+	f.Add("Action: a\nAction: b\nTags: x, y\nAction Input: {bad")
+	f.Add("Thought:\n\n  indented\n- bullet\nTags:")
+	labels := []Label{
+		{Name: "Thought"},
+		{Name: "Action", Repeatable: true},
+		{Name: "Action Input", IsJSON: true},
+		{Name: "Tags", SplitOn: ","},
+	}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		f.Fatalf("Failed to create parser: %v", err)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		result, _ := parser.Parse(text)
+		data, errs := parser.ParseToOrderedJSON(text)
+		if data == nil {
+			t.Skipf("result not encodable: %v", errs)
+		}
+		var ordered, expected interface{}
+		if err := json.Unmarshal(data, &ordered); err != nil {
+			t.Fatalf("Ordered JSON is invalid: %v\n%s", err, data)
+		}
+		plain, err := json.Marshal(result)
+		if err != nil {
+			t.Fatalf("Result not encodable by encoding/json: %v", err)
+		}
+		if err := json.Unmarshal(plain, &expected); err != nil {
+			t.Fatalf("Failed to decode result: %v", err)
+		}
+		if !ResultsEqual(ordered, expected) {
+			t.Errorf("Round trip mismatch:\nordered: %s\nplain:   %s", data, plain)
+		}
+	})
+}
+
+// TestMarshal verifies the text Marshal writes for each kind of value and
+// that it parses back to the same result.
+func TestMarshal(t *testing.T) {
+	labels := []Label{
+		{Name: "Thought"},
+		{Name: "Action", Repeatable: true},
+		{Name: "Action Input", IsJSON: true},
+		{Name: "Tags", SplitOn: ","},
+		{Name: "Details", NestedLabels: []Label{{Name: "Name"}, {Name: "Age"}}},
+		{Name: "Verified", FlagOnly: true},
+		{Name: "Note"},
+	}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse("Thought: plan\nit out\nAction: a\nAction: b\nAction Input: {\"q\": [1, 2]}\nTags: x, y\nDetails:\n  Name: bob\n  Age: 4\nVerified\nNote:\n:-)")
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	text, err := parser.Marshal(result)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "Thought: plan\nit out\nAction: a\nAction: b\nAction Input: {\"q\":[1,2]}\nTags: x, y\nDetails:\n  Name: bob\n  Age: 4\nVerified:\nNote:\n:-)"
+	if text != expected {
+		t.Errorf("Expected %q, got %q", expected, text)
+	}
+	if reparsed, _ := parser.Parse(text); !ResultsEqual(reparsed, result) {
+		t.Errorf("Expected %v, got %v", result, reparsed)
+	}
+
+	if _, err := parser.Marshal(map[string]interface{}{"Action Input": func() {}}); err == nil {
+		t.Error("Expected an error for a value JSON cannot encode")
+	}
+}
+
+// FuzzMarshalRoundTrip verifies that reparsing the marshaled form of a parse
+// result yields the same result, within the limits Marshal documents.
+func FuzzMarshalRoundTrip(f *testing.F) {
+	f.Add("Thought: hmm\nAction: search\nAction Input: {\"q\": [1, 2]}")
+	f.Add("Action: a\nAction:\nTags: x, y\nAction Input: {bad")
+	f.Add("Thought: line 1\n\n  line 2\nVerified\nTags:")
+	labels := []Label{
+		{Name: "Thought"},
+		{Name: "Action", Repeatable: true},
+		{Name: "Action Input", IsJSON: true},
+		{Name: "Tags", SplitOn: ","},
+		{Name: "Verified", FlagOnly: true},
+	}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		f.Fatalf("Failed to create parser: %v", err)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		if !utf8.ValidString(text) || strings.Contains(text, "`") {
+			t.Skip("not round-trippable")
+		}
+		result, _ := parser.Parse(text)
+		marshaled, err := parser.Marshal(result)
+		if err != nil {
+			t.Skipf("result not marshalable: %v", err)
+		}
+		reparsed, _ := parser.Parse(marshaled)
+		if !ResultsEqual(reparsed, result) {
+			t.Errorf("Round trip mismatch for %q:\nmarshaled: %q\noriginal: %#v\nreparsed: %#v", text, marshaled, result, reparsed)
+		}
+	})
+}
+
+// TestOpaqueLabel verifies that header-like values are stored verbatim.
+func TestOpaqueLabel(t *testing.T) {
+	labels := []Label{
+		{Name: "Headers", Opaque: true, NormalizeCase: "lower", IsJSON: true},
+		{Name: "Status"},
+		{Name: "Raw", Opaque: true},
+	}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	text := "Headers: Content-Type: application/json\n  X-Trace = a:b\nAccept: */*\nStatus: Content-Length: 5\nRaw: : kept"
+	result, errs := parser.Parse(text)
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	expected := map[string]interface{}{
+		"Headers": "Content-Type: application/json\n  X-Trace = a:b\nAccept: */*",
+		"Status":  "Content-Length: 5",
+		"Raw":     ": kept",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
+
+// TestPrefixOverlappingLabels verifies that labels that are prefixes of one
+// another, or share a suffix, are never confused and the longest label wins.
+func TestPrefixOverlappingLabels(t *testing.T) {
+	labels := []Label{{Name: "Act"}, {Name: "Action"}, {Name: "Reaction"}, {Name: "Action-Input"}}
+	for _, order := range [][]int{{0, 1, 2, 3}, {3, 2, 1, 0}} {
+		ordered := make([]Label, len(order))
+		for i, idx := range order {
+			ordered[i] = labels[idx]
+		}
+		parser, err := NewParser(ordered, &ParserOptions{WarnAmbiguousMatches: true})
+		if err != nil {
+			t.Fatalf("Failed to create parser: %v", err)
+		}
+		cases := []struct {
+			line, label, value string
+		}{
+			{"Act: a", "act", "a"},
+			{"Action: b", "action", "b"},
+			{"Reaction: c", "reaction", "c"},
+			{"Action-Input: d", "action-input", "d"},
+			{"Act-ion: e", "act", "ion: e"},
+			{"Actions: f", "", ""},
+			{"React: g", "", ""},
+		}
+		for _, tc := range cases {
+			if label, value := parser.parseLine(tc.line); label != tc.label || (label != "" && value != tc.value) {
+				t.Errorf("order %v, parseLine(%q): expected (%q, %q), got (%q, %q)", order, tc.line, tc.label, tc.value, label, value)
+			}
+		}
+		result, errs := parser.Parse("Action-Input: d\nReaction: c")
+		if result["Action-Input"] != "d" || result["Reaction"] != "c" || result["Action"] != "" {
+			t.Errorf("order %v: unexpected result %v", order, result)
+		}
+		if len(errs) != 1 || !strings.HasSuffix(errs[0], "using 'Action-Input'") {
+			t.Errorf("order %v: expected ambiguity warning naming the longest label, got %v", order, errs)
+		}
+	}
+}
+
+// TestAllowEmpty verifies that an empty but present label satisfies Required.
+func TestAllowEmpty(t *testing.T) {
+	labels := []Label{{Name: "Notes", Required: true, AllowEmpty: true}, {Name: "Answer", Required: true, RequiredWith: []string{"Notes"}}}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse("Notes:\nAnswer: 42")
+	if len(errs) > 0 {
+		t.Errorf("Expected empty Notes to satisfy Required and RequiredWith, got %v", errs)
+	}
+	if result["Notes"] != "" {
+		t.Errorf("Expected empty Notes value, got %q", result["Notes"])
+	}
+	_, errs = parser.Parse("Answer: 42")
+	if len(errs) != 2 {
+		t.Errorf("Expected absent Notes to fail both checks, got %v", errs)
+	}
+}
+
+// TestRedact verifies masking fields and nested JSON paths in a copy.
+func TestRedact(t *testing.T) {
+	labels := []Label{{Name: "Password"}, {Name: "Action Input", IsJSON: true, Repeatable: true}, {Name: "Tags", SplitOn: ","}}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	text := "Password: hunter2\nAction Input: {\"user\": \"a\", \"auth\": {\"token\": \"t1\"}}\nAction Input: {\"user\": \"b\", \"auth\": {\"token\": \"t2\"}}\nTags: public, secret"
+	result, _ := parser.Parse(text)
+	redacted := Redact(result, []string{"Password", "/Action Input/auth/token", "/Tags/1", "Missing", "/Nope/x"}, "***")
+
+	expected := map[string]interface{}{
+		"Password": "***",
+		"Action Input": []interface{}{
+			map[string]interface{}{"user": "a", "auth": map[string]interface{}{"token": "***"}},
+			map[string]interface{}{"user": "b", "auth": map[string]interface{}{"token": "***"}},
+		},
+		"Tags": []string{"public", "***"},
+	}
+	if !reflect.DeepEqual(redacted, expected) {
+		t.Errorf("Expected %v, got %v", expected, redacted)
+	}
+	if token, _ := GetPath(result, "/Action Input/0/auth/token"); token != "t1" || result["Password"] != "hunter2" {
+		t.Errorf("Expected the input to be unchanged, got %v", result)
+	}
+}
+
+// TestSplitInlineLabels verifies splitting labels concatenated on one line.
+func TestSplitInlineLabels(t *testing.T) {
+	labels := []Label{{Name: "Thought"}, {Name: "Action"}, {Name: "Action Input", IsJSON: true}, {Name: "Observation"}}
+	parser, err := NewParser(labels, &ParserOptions{SplitInlineLabels: true})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	cases := []struct {
+		text     string
+		expected map[string]interface{}
+	}{
+		{
+			"Thought: I should search Action: search",
+			map[string]interface{}{"Thought": "I should search", "Action": "search", "Action Input": "", "Observation": ""},
+		},
+		{
+			"Thought: hmm  Action: search Action Input: {\"note\": \"Observation: none\"}\nObservation: found it",
+			map[string]interface{}{"Thought": "hmm", "Action": "search", "Action Input": map[string]interface{}{"note": "Observation: none"}, "Observation": "found it"},
+		},
+		{
+			"Thought: hmm\nmore Action: thinking",
+			map[string]interface{}{"Thought": "hmm\nmore Action: thinking", "Action": "", "Action Input": "", "Observation": ""},
+		},
+	}
+	for _, tc := range cases {
+		result, errs := parser.Parse(tc.text)
+		if len(errs) > 0 {
+			t.Errorf("Parse(%q): unexpected errors %v", tc.text, errs)
+		}
+		if !reflect.DeepEqual(result, tc.expected) {
+			t.Errorf("Parse(%q): expected %v, got %v", tc.text, tc.expected, result)
+		}
+	}
+
+	plain, _ := NewParser(labels, nil)
+	if result, _ := plain.Parse("Thought: x Action: run"); result["Thought"] != "x Action: run" {
+		t.Errorf("Expected no splitting by default, got %v", result)
+	}
+}
+
+// TestCRLFInJSONValues verifies that CRLF and CR line endings never leave
+// carriage returns in parsed values.
+func TestCRLFInJSONValues(t *testing.T) {
+	labels := []Label{{Name: "Task", IsBlockStart: true}, {Name: "Config", IsJSON: true}, {Name: "Notes", Dedent: true}, {Name: "Summary"}}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	text := "Task: one\r\nConfig: {\r\n  \"name\": \"a b\",\r\n  \"tags\": [\"x\",\r\n \"y\"]\r\n}\r\nNotes:\r\n  line 1\r  line 2\r\nSummary: line1\r\nline2\r\n"
+	result, errs := parser.Parse(text)
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	expected := map[string]interface{}{"name": "a b", "tags": []interface{}{"x", "y"}}
+	if !reflect.DeepEqual(result["Config"], expected) {
+		t.Errorf("Expected %v, got %#v", expected, result["Config"])
+	}
+	if result["Notes"] != "line 1\nline 2" {
+		t.Errorf("Expected CR-free Notes, got %q", result["Notes"])
+	}
+	if result["Summary"] != "line1\nline2" {
+		t.Errorf("Expected CR-free Summary, got %q", result["Summary"])
+	}
+
+	blocks, _ := parser.ParseBlocks(text)
+	var read []map[string]interface{}
+	err = parser.ParseBlocksReader(strings.NewReader(text), func(_ int, block map[string]interface{}, _ []string) error {
+		if !reflect.DeepEqual(block["Config"], expected) || block["Notes"] != "line 1\nline 2" {
+			t.Errorf("Expected CR-free block, got %#v", block)
+		}
+		read = append(read, block)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(read, blocks) {
+		t.Errorf("Expected ParseBlocksReader to match ParseBlocks %#v, got %#v", blocks, read)
+	}
+}
+
+// TestEnforceOrder verifies that fields appearing out of declaration order
+// are reported.
+func TestEnforceOrder(t *testing.T) {
+	labels := []Label{{Name: "Thought"}, {Name: "Action"}, {Name: "Observation"}, {Name: "Answer"}}
+	parser, err := NewParser(labels, &ParserOptions{EnforceOrder: true})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	cases := []struct {
+		text     string
+		expected []string
+	}{
+		{"Thought: a\nAction: b\nAnswer: c", nil},
+		{"Thought: a\nAction: b\nThought: again", []string{"warning: 'Thought' appears multiple times but is not repeatable; keeping every value"}},
+		{"Action: b\nThought: a\nAnswer: c", []string{"'Thought' appears after 'Action' but is declared before it"}},
+		{"Answer: c\nThought: a\nAction: b", []string{
+			"'Thought' appears after 'Answer' but is declared before it",
+			"'Action' appears after 'Answer' but is declared before it",
+		}},
+	}
+	for _, tc := range cases {
+		_, errs := parser.Parse(tc.text)
+		if len(errs) != len(tc.expected) || (len(errs) > 0 && !reflect.DeepEqual(errs, tc.expected)) {
+			t.Errorf("Parse(%q): expected %v, got %v", tc.text, tc.expected, errs)
+		}
+	}
+}
+
+// TestStats verifies line classification counts.
+func TestStats(t *testing.T) {
+	labels := []Label{{Name: "Thought"}, {Name: "Action"}, {Name: "Action Input", IsJSON: true}}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	text := "Thought: hmm\nstill thinking\nAction: search\nAction Input: {\"q\": 1}\nConfidence: high\n\nAction Input: {}"
+	expected := ParseStats{Lines: 7, MatchedLabels: 4, UnknownLabels: 1, JSONFields: 2}
+	if stats := parser.Stats(text); stats != expected {
+		t.Errorf("Expected %+v, got %+v", expected, stats)
+	}
+	if stats := parser.Stats("  "); stats != (ParseStats{}) {
+		t.Errorf("Expected zero stats for blank text, got %+v", stats)
+	}
+}
+
+// TestRequireExactCase verifies that a label can demand its declared casing.
+func TestRequireExactCase(t *testing.T) {
+	labels := []Label{{Name: "Thought"}, {Name: "Action", RequireExactCase: true}}
+	parser, err := NewParser(labels, &ParserOptions{FuzzyLabelDistance: 1})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	result, _ := parser.Parse("thought: the next\naction: is unclear\nAction: search")
+	if result["Thought"] != "the next\naction: is unclear" || result["Action"] != "search" {
+		t.Errorf("Expected only 'Action:' to match, got %v", result)
+	}
+	for _, line := range []string{"action: x", "ACTION: x", "Actio: x"} {
+		if name, _ := parser.parseLine(line); name != "" {
+			t.Errorf("parseLine(%q): expected no match, got %q", line, name)
+		}
+	}
+}
+
+// TestSkipPreambleUntilFirstLabel verifies that chatty introductions are
+// discarded before the first label.
+func TestSkipPreambleUntilFirstLabel(t *testing.T) {
+	labels := []Label{{Name: "Thought"}, {Name: "Action"}}
+	parser, err := NewParser(labels, &ParserOptions{SkipPreambleUntilFirstLabel: true, BlockSeparatorPattern: "^-{3,}$"})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	text := "Sure, here's the output: \\{{\n\nThought: hmm\nAction: run\n---\nThought: again"
+	result, errs := parser.ParseE(text)
+	if len(errs) != 1 || !errs[0].Warning || !reflect.DeepEqual(result["Thought"], []interface{}{"hmm", "again"}) || result["Action"] != "run\n---" {
+		t.Errorf("Expected the preamble to be skipped, got %v, %v", result, errs)
+	}
+
+	expected := []map[string]interface{}{{"Thought": "hmm", "Action": "run"}, {"Thought": "again", "Action": ""}}
+	blocks, _ := parser.ParseBlocks(text)
+	if !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("Expected no preamble block, got %v", blocks)
+	}
+	var streamed []map[string]interface{}
+	parser.ParseBlocksReader(strings.NewReader(text), func(_ int, block map[string]interface{}, _ []string) error {
+		streamed = append(streamed, block)
+		return nil
+	})
+	if !reflect.DeepEqual(streamed, expected) {
+		t.Errorf("Expected no preamble block when streaming, got %v", streamed)
+	}
+}
+
+// TestMaxJSONDepth verifies that deeply nested JSON values are rejected.
+func TestMaxJSONDepth(t *testing.T) {
+	labels := []Label{{Name: "Data", IsJSON: true}}
+	nested := func(depth int) string {
+		return strings.Repeat("[", depth) + strings.Repeat("]", depth)
+	}
+
+	parser, _ := NewParser(labels, &ParserOptions{MaxJSONDepth: 3})
+	if _, errs := parser.Parse("Data: {\"a\": [[1]], \"s\": \"[[[[\"}"); len(errs) > 0 {
+		t.Errorf("Expected depth 3 to be allowed, got %v", errs)
+	}
+	result, structured := parser.ParseE("Data: {\"a\": [[[1]]]}")
+	if len(structured) != 1 || !errors.Is(structured[0], ErrJSONDepth) || structured[0].Message != "JSON in 'Data' exceeds the maximum nesting depth of 3" {
+		t.Errorf("Expected depth error, got %v", structured)
+	}
+	if result["Data"] != "{\"a\": [[[1]]]}" {
+		t.Errorf("Expected the raw value to be kept, got %v", result["Data"])
+	}
+
+	defaults, _ := NewParser(labels, nil)
+	if _, errs := defaults.Parse("Data: " + nested(100)); len(errs) > 0 {
+		t.Errorf("Expected the default limit to allow depth 100, got %v", errs)
+	}
+	if _, errs := defaults.Parse("Data: " + nested(101)); len(errs) != 1 {
+		t.Errorf("Expected the default limit to reject depth 101, got %v", errs)
+	}
+	unlimited, _ := NewParser(labels, &ParserOptions{MaxJSONDepth: -1})
+	if _, errs := unlimited.Parse("Data: " + nested(500)); len(errs) > 0 {
+		t.Errorf("Expected no limit, got %v", errs)
+	}
+}
+
+// TestLabelMeta verifies that label metadata is carried through unchanged.
+func TestLabelMeta(t *testing.T) {
+	labels := []Label{
+		{Name: "Thought", Meta: map[string]string{"display": "Reasoning", "group": "internal"}},
+		{Name: "Answer", Required: true},
+	}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	got := parser.Labels()
+	if len(got) != 2 || got[0].Name != "Thought" || got[0].Meta["display"] != "Reasoning" || got[1].Meta != nil || !got[1].Required {
+		t.Errorf("Expected declared labels with Meta, got %+v", got)
+	}
+	got[0].Meta["display"] = "changed"
+	if parser.Labels()[0].Meta["display"] != "Reasoning" {
+		t.Error("Expected Labels to return a copy of Meta")
+	}
+	if result, _ := parser.Parse("Thought: hmm\nAnswer: 42"); result["Thought"] != "hmm" {
+		t.Errorf("Expected Meta not to affect parsing, got %v", result)
+	}
+}
+
+// TestNumberedSuffix verifies that numbered variants of a label are collected
+// in order.
+func TestNumberedSuffix(t *testing.T) {
+	labels := []Label{{Name: "Step", NumberedSuffix: true}, {Name: "Answer"}}
+	parser, err := NewParser(labels, &ParserOptions{DuplicatePolicy: DuplicateKeepFirst})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse("Step 1: read\nStep 2: think\n  more\nstep #3 - check\nStep: last\nSteps: no\nAnswer: 42")
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	expected := []interface{}{"read", "think\n  more", "check", "last\nSteps: no"}
+	if !reflect.DeepEqual(result["Step"], expected) {
+		t.Errorf("Expected %q, got %q", expected, result["Step"])
+	}
+	if match, ok := parser.ClassifyLine("Step 12: x"); !ok || match.AsWritten != "Step 12" {
+		t.Errorf("Expected the number in AsWritten, got %+v", match)
+	}
+
+	single, _ := parser.Parse("Step 1: only")
+	if !reflect.DeepEqual(single["Step"], []interface{}{"only"}) {
+		t.Errorf("Expected a slice for a single step, got %#v", single["Step"])
+	}
+}
+
+// TestJSONTargetDisallowUnknownKeys verifies typed JSON decoding and the
+// rejection of keys the target type does not declare.
+func TestJSONTargetDisallowUnknownKeys(t *testing.T) {
+	type config struct {
+		Name  string `json:"name"`
+		Level int    `json:"level"`
+	}
+	labels := []Label{{Name: "Config", IsJSON: true, JSONTarget: config{}, DisallowUnknownJSONKeys: true}}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+
+	result, errs := parser.Parse(`Config: {"name": "a", "level": 2}`)
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	if !reflect.DeepEqual(result["Config"], config{Name: "a", Level: 2}) {
+		t.Errorf("Expected a typed config, got %#v", result["Config"])
+	}
+
+	_, parseErrs := parser.ParseE(`Config: {"name": "a", "xyz": true}`)
+	if len(parseErrs) != 1 || parseErrs[0].Code != CodeUnknownJSONKey || parseErrs[0].Message != "'Config' has unknown field 'xyz'" {
+		t.Errorf("Expected an unknown field error, got %v", parseErrs)
+	}
+
+	lenient, _ := NewParser([]Label{{Name: "Config", IsJSON: true, JSONTarget: &config{}}}, nil)
+	result, errs = lenient.Parse(`Config: {"name": "b", "xyz": true}`)
+	if len(errs) > 0 || !reflect.DeepEqual(result["Config"], &config{Name: "b"}) {
+		t.Errorf("Expected unknown keys to be ignored, got %#v %v", result["Config"], errs)
+	}
+
+	if _, err := NewParser([]Label{{Name: "Config", DisallowUnknownJSONKeys: true}}, nil); err == nil {
+		t.Error("Expected an error for disallowUnknownJsonKeys without jsonTarget")
+	}
+}
+
+// TestJSONTargetLargeInt verifies that integers beyond float64 precision are
+// decoded into a JSONTarget exactly.
+func TestJSONTargetLargeInt(t *testing.T) {
+	type args struct {
+		ID int64 `json:"id"`
+	}
+	parser, err := NewParser([]Label{{Name: "Args", IsJSON: true, JSONTarget: args{}}}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse(`Args: {"id": 9007199254740993}`)
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	if !reflect.DeepEqual(result["Args"], args{ID: 9007199254740993}) {
+		t.Errorf("Expected an exact id, got %#v", result["Args"])
+	}
+}
+
+// TestTokenize verifies token kinds and raw byte offsets, including across
+// CRLF line endings and code fences.
+func TestTokenize(t *testing.T) {
+	parser, err := NewParser([]Label{{Name: "Thought"}, {Name: "Action Input"}}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	text := "```\r\nThought: plan it\r\n  more \r\naction input :: {\"a\": 1}\r\n```"
+	var got []string
+	for _, tok := range parser.Tokenize(text) {
+		got = append(got, tok.Kind+"="+text[tok.Start:tok.End])
+	}
+	expected := []string{
+		"code-fence=```",
+		"label-name=Thought", "separator=:", "value=plan it",
+		"value=more",
+		"label-name=action input", "separator=::", `value={"a": 1}`,
+		"code-fence=```",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+	if tokens := parser.Tokenize("preamble\n\n"); len(tokens) != 0 {
+		t.Errorf("Expected no tokens before the first label, got %v", tokens)
+	}
+
+	// Fences opened after a label, on its line or the next, and escape
+	// fences hold values even where a line looks like a label.
+	text = "Thought: a\nAction Input: ```json\nThought: no\n```\nThought:\n```\nThought: no\n```\nThought: \\{{\nThought: no }}"
+	got = nil
+	for _, tok := range parser.Tokenize(text) {
+		got = append(got, tok.Kind+"="+text[tok.Start:tok.End])
+	}
+	expected = []string{
+		"label-name=Thought", "separator=:", "value=a",
+		"label-name=Action Input", "separator=:", "code-fence=```json",
+		"value=Thought: no",
+		"code-fence=```",
+		"label-name=Thought", "separator=:",
+		"code-fence=```",
+		"value=Thought: no",
+		"code-fence=```",
+		"label-name=Thought", "separator=:", `value=\{{`,
+		"value=Thought: no }}",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+// TestSkipEmptyBlocks verifies how blocks holding only their block start
+// label are handled, both back to back and at the end of the text.
+func TestSkipEmptyBlocks(t *testing.T) {
+	labels := []Label{{Name: "Task", IsBlockStart: true}, {Name: "Status"}}
+	text := "Task: one\nTask: two\nStatus: done\nTask: three"
+
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	blocks, _ := parser.ParseBlocks(text)
+	if len(blocks) != 3 || blocks[0]["Task"] != "one" || blocks[0]["Status"] != "" || blocks[2]["Task"] != "three" {
+		t.Errorf("Expected start-only blocks to be emitted by default, got %v", blocks)
+	}
+
+	skipping, err := NewParser(labels, &ParserOptions{SkipEmptyBlocks: true})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	blocks, errs := skipping.ParseBlocks(text)
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	expected := []map[string]interface{}{{"Task": "two", "Status": "done"}}
+	if !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("Expected %v, got %v", expected, blocks)
+	}
+}
+
+// TestResultsEqualIgnoring verifies that ignored fields, present or not, do
+// not affect the comparison.
+func TestResultsEqualIgnoring(t *testing.T) {
+	a := map[string]interface{}{"Answer": "42", "Timestamp": "10:00", "Steps": []interface{}{"a"}}
+	b := map[string]interface{}{"Answer": "42", "Timestamp": "10:05", "Steps": []interface{}{"a"}}
+	if !ResultsEqualIgnoring(a, b, []string{"Timestamp"}) {
+		t.Error("Expected results to be equal ignoring Timestamp")
+	}
+	if ResultsEqualIgnoring(a, b, nil) {
+		t.Error("Expected results to differ when nothing is ignored")
+	}
+	delete(b, "Timestamp")
+	if !ResultsEqualIgnoring(a, b, []string{"Timestamp"}) {
+		t.Error("Expected a missing ignored field to be equal")
+	}
+	b["Answer"] = "41"
+	if ResultsEqualIgnoring(a, b, []string{"Timestamp"}) {
+		t.Error("Expected results to differ on Answer")
+	}
+}
+
+// TestValuesRoundTrip verifies that a result survives conversion to
+// url.Values and back.
+func TestValuesRoundTrip(t *testing.T) {
+	labels := []Label{
+		{Name: "Thought", Repeatable: true},
+		{Name: "Action Input", IsJSON: true},
+		{Name: "Tags", SplitOn: ","},
+		{Name: "Done", FlagOnly: true},
+		{Name: "Answer"},
+	}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse("Thought: a\nThought: b\nAction Input: {\"q\": [1, 2]}\nTags: x, y\nDone")
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+
+	values := ToValues(result)
+	if !reflect.DeepEqual(values["Thought"], []string{"a", "b"}) || values.Get("Action Input") != `{"q":[1,2]}` || values.Get("Done") != "true" {
+		t.Errorf("Unexpected values: %v", values)
+	}
+	if back := FromValues(values, labels); !ResultsEqual(back, result) {
+		t.Errorf("Expected %v, got %v", result, back)
+	}
+}
+
+// TestParseWithFallbacks verifies that the first error-free parser wins and
+// that the fewest errors win otherwise.
+func TestParseWithFallbacks(t *testing.T) {
+	react, _ := NewParser([]Label{{Name: "Thought", Required: true}, {Name: "Action", Required: true}}, nil)
+	answer, _ := NewParser([]Label{{Name: "Answer", Required: true}}, nil)
+	loose, _ := NewParser([]Label{{Name: "Answer"}}, nil)
+
+	result, index, errs := ParseWithFallbacks("Answer: 42", react, answer, loose)
+	if index != 1 || len(errs) != 0 || result["Answer"] != "42" {
+		t.Errorf("Expected the answer parser to win, got %d %v %v", index, result, errs)
+	}
+
+	cited, _ := NewParser([]Label{{Name: "Answer", Required: true}, {Name: "Source", Required: true}}, nil)
+	_, index, errs = ParseWithFallbacks("Thought: hmm", cited, react, answer)
+	if index != 1 || len(errs) != 1 {
+		t.Errorf("Expected the parser with fewest errors to win, got %d %v", index, errs)
+	}
+
+	if result, index, errs := ParseWithFallbacks("Answer: 42"); result != nil || index != -1 || errs != nil {
+		t.Errorf("Expected no result without parsers, got %v %d %v", result, index, errs)
+	}
+}
+
+// TestParseBlocksWithStyle verifies the per-block dominant separator and
+// indentation.
+func TestParseBlocksWithStyle(t *testing.T) {
+	labels := []Label{{Name: "Task", IsBlockStart: true}, {Name: "Status"}, {Name: "Notes"}}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	text := "Task: one\nStatus: done\nNotes - a\n    more\n    still\n\tother\n" +
+		"Task = two\nStatus = open\nNotes: b"
+	blocks, styles, errs := parser.ParseBlocksWithStyle(text)
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	expected := []BlockStyle{{Separator: ":", Indent: "    "}, {Separator: "=", Indent: ""}}
+	if len(blocks) != 2 || !reflect.DeepEqual(styles, expected) {
+		t.Errorf("Expected %q, got %q for %v", expected, styles, blocks)
+	}
+	plain, _ := parser.ParseBlocks(text)
+	if !ResultsEqual(blocks, plain) {
+		t.Errorf("Expected the same blocks as ParseBlocks, got %v and %v", blocks, plain)
+	}
+}
+
+// TestPatternIndexMatchesUnindexed verifies that matching through the
+// first-word index of a large schema gives the same output as trying every
+// pattern.
+func TestPatternIndexMatchesUnindexed(t *testing.T) {
+	labels := []Label{
+		{Name: "Step", NumberedSuffix: true},
+		{Name: "Action"},
+		{Name: "Action Input", IsJSON: true},
+		{Name: "Strasse"},
+		{Name: "ID", RequireExactCase: true},
+		{Name: "Q.A"},
+		{Name: "  Padded"},
+	}
+	for i := 0; i < minIndexedPatterns; i++ {
+		labels = append(labels, Label{Name: "Extra" + strconv.Itoa(i)})
+	}
+	parser, err := NewParser(labels, &ParserOptions{
+		FlexibleWordSeparators: true,
+		GlobalAliases:          map[string]string{"Tool": "Action"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	if parser.patternIdx == nil {
+		t.Fatal("Expected a pattern index for a large schema")
+	}
+	unindexed := *parser
+	unindexed.patternIdx = nil
+
+	text := "step 2: one\n  Step3 - two\nACTION_INPUT: {\"a\": 1}\naction: run\n\tTool = go\n" +
+		"ſtrasse: long s\nid: lower\nID: upper\nQxA: not the label\nQ.A: meta\npadded: yes\nExtra7: x\nextra12 :: y\nnothing: here"
+	want, wantErrs := unindexed.Parse(text)
+	got, gotErrs := parser.Parse(text)
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(gotErrs, wantErrs) {
+		t.Errorf("Expected %v %v, got %v %v", want, wantErrs, got, gotErrs)
+	}
+	if got["Strasse"] != "long s\nid: lower" || got["ID"] != "upper\nQxA: not the label" || got["Q.A"] != "meta" {
+		t.Errorf("Unexpected matches: %v", got)
+	}
+}
+
+// TestTrimTrailing verifies that trailing punctuation is stripped before
+// enum matching while leading and inner punctuation is kept.
+func TestTrimTrailing(t *testing.T) {
+	labels := []Label{
+		{Name: "Status", TrimTrailing: ".!", Enum: []string{"done", "failed"}},
+		{Name: "Note", TrimTrailing: ".!?"},
+		{Name: "Raw"},
+	}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse("Status: done!!.\nNote: ...e.g. this works ?!.\nRaw: kept!")
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	expected := map[string]interface{}{"Status": "done", "Note": "...e.g. this works", "Raw": "kept!"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
+
+// TestFallbackSingleBlock verifies that input without any block start is
+// parsed as one block only when FallbackSingleBlock is set.
+func TestFallbackSingleBlock(t *testing.T) {
+	labels := []Label{{Name: "Task", IsBlockStart: true}, {Name: "Status"}, {Name: "Notes"}}
+	text := "Status: done\nNotes: all good\n  really"
+
+	parser, _ := NewParser(labels, nil)
+	if blocks, _ := parser.ParseBlocks(text); len(blocks) != 0 {
+		t.Errorf("Expected no blocks by default, got %v", blocks)
+	}
+
+	fallback, err := NewParser(labels, &ParserOptions{FallbackSingleBlock: true})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	blocks, errs := fallback.ParseBlocks(text)
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	expected := []map[string]interface{}{{"Task": "", "Status": "done", "Notes": "all good\n  really"}}
+	if !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("Expected %v, got %v", expected, blocks)
+	}
+
+	blocks, _ = fallback.ParseBlocks("intro\nTask: one\nStatus: open")
+	if len(blocks) != 1 || blocks[0]["Task"] != "one" {
+		t.Errorf("Expected normal splitting once a block starts, got %v", blocks)
+	}
+
+	var streamed []map[string]interface{}
+	if err := fallback.ParseBlocksReader(strings.NewReader(text), func(_ int, block map[string]interface{}, _ []string) error {
+		streamed = append(streamed, block)
+		return nil
+	}); err != nil || !reflect.DeepEqual(streamed, expected) {
+		t.Errorf("Expected the reader to fall back too, got %v %v", streamed, err)
+	}
+}
+
+// TestResolveBlockRefs verifies reference resolution by id and by block
+// number, and the errors for dangling references and duplicate ids.
+func TestResolveBlockRefs(t *testing.T) {
+	labels := []Label{{Name: "Task", IsBlockStart: true}, {Name: "ID"}, {Name: "Depends On", Repeatable: true}}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	blocks, _ := parser.ParseBlocks("Task: fetch\nID: a\n" +
+		"Task: parse\nID: b\nDepends On: A\n" +
+		"Task: report\nID: c\nDepends On: a, b\nDepends On: z\n" +
+		"Task: again\nID: b")
+
+	resolved, errs := ResolveBlockRefs(blocks, "ID", "Depends On")
+	expectedErrs := []string{"duplicate 'ID' value 'b' in block 4", "block 3: 'Depends On' references unknown block 'z'"}
+	if !reflect.DeepEqual(errs, expectedErrs) {
+		t.Errorf("Expected errors %q, got %q", expectedErrs, errs)
+	}
+	var links [][]int
+	for _, block := range resolved {
+		links = append(links, block["Depends OnResolved"].([]int))
+	}
+	if expected := [][]int{{}, {0}, {0, 1}, {}}; !reflect.DeepEqual(links, expected) {
+		t.Errorf("Expected links %v, got %v", expected, links)
+	}
+	if _, annotated := blocks[1]["Depends OnResolved"]; annotated {
+		t.Error("Expected the input blocks to be left unchanged")
+	}
+
+	numbered := []map[string]interface{}{{"Needs": ""}, {"Needs": "block 1"}, {"Needs": "4"}}
+	resolved, errs = ResolveBlockRefs(numbered, "", "Needs")
+	if len(errs) != 1 || !reflect.DeepEqual(resolved[1]["NeedsResolved"], []int{0}) {
+		t.Errorf("Expected block number references to resolve, got %v %v", resolved, errs)
+	}
+}
+
+// TestNormalizeCaseLists verifies that case folding reaches every repeated
+// entry and list item while JSON values keep their casing.
+func TestNormalizeCaseLists(t *testing.T) {
+	labels := []Label{
+		{Name: "Tag", Repeatable: true, NormalizeCase: "lower"},
+		{Name: "Colors", SplitOn: ",", NormalizeCase: "title"},
+		{Name: "Notes", ParagraphsAsArray: true, NormalizeCase: "upper"},
+		{Name: "Data", IsJSON: true, NormalizeCase: "lower"},
+	}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse("Tag: URGENT\nTag: Bug\nTag: ui\nColors: RED,dark green\nNotes: one\n\nTwo\nData: {\"Key\": \"MiXed\"}")
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	expected := map[string]interface{}{
+		"Tag":    []interface{}{"urgent", "bug", "ui"},
+		"Colors": []string{"Red", "Dark Green"},
+		"Notes":  []string{"ONE", "TWO"},
+		"Data":   map[string]interface{}{"Key": "MiXed"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %v, got %v", expected, result)
+	}
+}
+
+// TestPreserveIndent verifies that an indented first continuation line keeps
+// its whitespace only for PreserveIndent labels.
+func TestPreserveIndent(t *testing.T) {
+	labels := []Label{{Name: "Stack Trace", PreserveIndent: true}, {Name: "Summary"}}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse("Stack Trace:\n\n    at main()\n      at run()\n  caused by x\n\nSummary:\n    crashed")
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	expected := map[string]interface{}{
+		"Stack Trace": "    at main()\n      at run()\n  caused by x",
+		"Summary":     "crashed",
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+}
+
+// TestSeparatorInLabelName verifies that label and alias names containing a
+// separator are rejected, except for hyphenated words.
+func TestSeparatorInLabelName(t *testing.T) {
+	if _, err := NewParser([]Label{{Name: "A:B"}}, nil); err == nil || !strings.Contains(err.Error(), "contains the separator ':'") {
+		t.Errorf("Expected a separator error, got %v", err)
+	}
+	if _, err := NewParser([]Label{{Name: "A"}}, &ParserOptions{GlobalAliases: map[string]string{"X=Y": "A"}}); err == nil {
+		t.Error("Expected a separator error for an alias")
+	}
+	if _, err := NewParser([]Label{{Name: "Follow-up"}, {Name: "Step - 1"}}, nil); err == nil || !strings.Contains(err.Error(), "'Step - 1'") {
+		t.Errorf("Expected only the spaced dash to be rejected, got %v", err)
+	}
+	parser, err := NewParser([]Label{{Name: "A:B"}}, &ParserOptions{Separators: "="})
+	if err != nil {
+		t.Fatalf("Expected a name without active separators to be accepted, got %v", err)
+	}
+	if err := parser.SetSeparators(":="); err == nil {
+		t.Error("Expected SetSeparators to reject a separator used in a label name")
+	}
+	if result, _ := parser.Parse("A:B = value"); result["A:B"] != "value" {
+		t.Errorf("Expected the parser to be unchanged, got %v", result)
+	}
+}
+
+// TestParseTyped verifies decoding into a struct by tag and by field name,
+// and the zero value on a decoding failure.
+func TestParseTyped(t *testing.T) {
+	type step struct {
+		Thought     []string               `json:"Thought"`
+		Action      string                 // Matched by name
+		ActionInput map[string]interface{} `json:"Action Input"`
+		Retries     int                    `json:"Retries,string"`
+		Answer      string
+	}
+	labels := []Label{
+		{Name: "Thought", Repeatable: true},
+		{Name: "Action", Required: true},
+		{Name: "Action Input", IsJSON: true},
+		{Name: "Retries"},
+		{Name: "Answer"},
+	}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+
+	got, errs := ParseTyped[step](parser, "Thought: a\nThought: b\nAction: search\nAction Input: {\"q\": \"go\"}\nRetries: 2")
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	expected := step{Thought: []string{"a", "b"}, Action: "search", ActionInput: map[string]interface{}{"q": "go"}, Retries: 2}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, got)
+	}
+
+	got, errs = ParseTyped[step](parser, "Retries: many")
+	if !reflect.DeepEqual(got, step{}) || len(errs) != 2 || !strings.HasPrefix(errs[1], "cannot decode result into structuredparse.step") {
+		t.Errorf("Expected the zero value and a decoding error, got %+v %v", got, errs)
+	}
+}
+
+// TestUnwrapOuterFenceOnly verifies that only a fence enclosing the whole
+// response is removed, keeping inner fences and inline code.
+func TestUnwrapOuterFenceOnly(t *testing.T) {
+	labels := []Label{{Name: "Explanation"}, {Name: "Code"}, {Name: "Args", IsJSON: true}}
+	parser, err := NewParser(labels, &ParserOptions{UnwrapOuterFenceOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+
+	wrapped := "```text\nExplanation: use `go test`\nCode:\n```go\nfmt.Println(1)\n```\nArgs: ```json\n{\"v\": true}\n```\n```"
+	result, errs := parser.Parse(wrapped)
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	expected := map[string]interface{}{
+		"Explanation": "use `go test`",
+		"Code":        "```go\nfmt.Println(1)\n```",
+		"Args":        map[string]interface{}{"v": true},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Expected %q, got %q", expected, result)
+	}
+
+	// Separate fences are not a whole-response fence and stay as written.
+	separate := "```\nExplanation: first\n```\ntext\n```\nCode: second\n```"
+	if got := unwrapOuterFence(separate); got != separate {
+		t.Errorf("Expected separate fences to be kept, got %q", got)
+	}
+
+	defaults, _ := NewParser(labels, nil)
+	if result, _ := defaults.Parse(wrapped); result["Explanation"] != "use go test" {
+		t.Errorf("Expected all code markup to be stripped by default, got %q", result["Explanation"])
+	}
+}
+
+// TestUnwrapOuterFenceOnlyReader verifies that ParseBlocksReader keeps inner
+// fences under UnwrapOuterFenceOnly, as ParseBlocks does.
+func TestUnwrapOuterFenceOnlyReader(t *testing.T) {
+	labels := []Label{{Name: "Task", IsBlockStart: true}, {Name: "Code"}}
+	parser, err := NewParser(labels, &ParserOptions{UnwrapOuterFenceOnly: true})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	inner := "Task: a\nCode:\n```\nx\n```\nTask: b"
+	for _, text := range []string{inner, "```\n" + inner + "\n```"} {
+		expected, _ := parser.ParseBlocks(text)
+		if expected[0]["Code"] != "```\nx\n```" {
+			t.Fatalf("Expected the inner fence to be kept, got %q", expected[0]["Code"])
+		}
+		var got []map[string]interface{}
+		err := parser.ParseBlocksReader(strings.NewReader(text), func(_ int, block map[string]interface{}, _ []string) error {
+			got = append(got, block)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Errorf("Expected %q, got %q for %q", expected, got, text)
+		}
+	}
+}
+
+// TestParseValidated verifies that errors are sorted into the report by kind.
+func TestParseValidated(t *testing.T) {
+	labels := []Label{
+		{Name: "Thought", Required: true},
+		{Name: "Action", RequiredWith: []string{"Action Input"}},
+		{Name: "Action Input", IsJSON: true},
+		{Name: "Config", IsJSON: true},
+		{Name: "Mode", Enum: []string{"fast", "slow"}},
+	}
+	parser, err := NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+
+	_, report := parser.ParseValidated("Action: search\nConfig: {bad\nMode: medium")
+	expected := ValidationReport{
+		MissingRequired:    []string{"Thought"},
+		FailedDependencies: []DependencyFailure{{Label: "Action", Dependency: "Action Input", Message: "'Action' requires 'Action Input'"}},
+	}
+	if !reflect.DeepEqual(report.MissingRequired, expected.MissingRequired) || !reflect.DeepEqual(report.FailedDependencies, expected.FailedDependencies) {
+		t.Errorf("Expected %+v, got %+v", expected, report)
+	}
+	if len(report.JSONErrors) != 1 || report.JSONErrors[0].Label != "Config" || report.JSONErrors[0].Code != CodeJSON {
+		t.Errorf("Expected a JSON error for Config, got %+v", report.JSONErrors)
+	}
+	if len(report.Other) != 1 || report.Other[0].Code != CodeEnum || report.Valid() {
+		t.Errorf("Expected an enum error in Other and an invalid report, got %+v", report.Other)
+	}
+
+	result, report := parser.ParseValidated("Thought: ok\nMode: fast")
+	if !report.Valid() || result["Mode"] != "fast" {
+		t.Errorf("Expected a valid report, got %+v %v", report, result)
+	}
+}
+
+// TestBlockStartInsideMultilineJSON verifies that block start lines nested
+// deep in a multi-line JSON value neither split the block nor end the value,
+// both in ParseBlocks and in ParseBlocksReader.
+func TestBlockStartInsideMultilineJSON(t *testing.T) {
+	labels := []Label{{Name: "Task", IsBlockStart: true}, {Name: "Payload", IsJSON: true}, {Name: "Status"}}
+	parser, err := NewParser(labels, &ParserOptions{StripLabelPrefixRunes: `"`})
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	text := "Task: one\nPayload: {\n  \"steps\": [\n    {\"next\": [\n\"Task: two\",\n\"Status: skipped\"\n    ]}\n  ]\n}\nStatus: done\nTask: three\nStatus: open"
+	expected := []map[string]interface{}{
+		{"Task": "one", "Status": "done", "Payload": map[string]interface{}{
+			"steps": []interface{}{map[string]interface{}{"next": []interface{}{"Task: two", "Status: skipped"}}},
+		}},
+		{"Task": "three", "Status": "open", "Payload": ""},
+	}
+
+	blocks, errs := parser.ParseBlocks(text)
+	if len(errs) > 0 || !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("Expected %v, got %v %v", expected, blocks, errs)
+	}
+
+	var streamed []map[string]interface{}
+	err = parser.ParseBlocksReader(strings.NewReader(text), func(_ int, block map[string]interface{}, blockErrs []string) error {
+		if len(blockErrs) > 0 {
+			t.Errorf("Unexpected errors: %v", blockErrs)
+		}
+		streamed = append(streamed, block)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(streamed, expected) {
+		t.Errorf("Expected %v from the reader, got %v %v", expected, streamed, err)
+	}
+}
@@ -0,0 +1,49 @@
+// Package pb converts structured-parse results to protocol buffer values. It
+// lives in its own package so that the core parser does not depend on protobuf.
+package pb
+
+import (
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// ToStructpb converts a parse result, from Parse or a single ParseBlocks
+// block, into a structpb.Struct. Single values and the slices produced by
+// repeated labels, SplitOn or ParagraphsAsArray are converted alike, and
+// parsed JSON values are converted recursively. Numbers become float64
+// values, as in JSON.
+func ToStructpb(result map[string]interface{}) (*structpb.Struct, error) {
+	return structpb.NewStruct(normalize(result).(map[string]interface{}))
+}
+
+// normalize rewrites the concrete slice and map types a result may contain
+// into the generic forms structpb accepts.
+func normalize(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = normalize(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = normalize(item)
+		}
+		return out
+	case []string:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = item
+		}
+		return out
+	case []map[string]interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = normalize(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,43 @@
+package pb
+
+import (
+	"testing"
+
+	structuredparse "github.com/hlfshell/structured-parse/go"
+)
+
+// TestToStructpb verifies converting a parse result with mixed value shapes.
+func TestToStructpb(t *testing.T) {
+	labels := []structuredparse.Label{
+		{Name: "Thought"},
+		{Name: "Action", Repeatable: true},
+		{Name: "Action Input", IsJSON: true},
+		{Name: "Tags", SplitOn: ","},
+	}
+	parser, err := structuredparse.NewParser(labels, nil)
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+	result, errs := parser.Parse("Thought: hmm\nAction: search\nAction Input: {\"q\": \"go\", \"n\": 3, \"opts\": [true, null]}\nTags: a, b")
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected errors: %v", errs)
+	}
+	s, err := ToStructpb(result)
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	fields := s.GetFields()
+	if fields["Thought"].GetStringValue() != "hmm" {
+		t.Errorf("Expected Thought 'hmm', got %v", fields["Thought"])
+	}
+	if actions := fields["Action"].GetListValue().GetValues(); len(actions) != 1 || actions[0].GetStringValue() != "search" {
+		t.Errorf("Expected Action list, got %v", fields["Action"])
+	}
+	input := fields["Action Input"].GetStructValue().GetFields()
+	if input["n"].GetNumberValue() != 3 || len(input["opts"].GetListValue().GetValues()) != 2 {
+		t.Errorf("Expected nested JSON values, got %v", input)
+	}
+	if tags := fields["Tags"].GetListValue().GetValues(); len(tags) != 2 || tags[1].GetStringValue() != "b" {
+		t.Errorf("Expected Tags list, got %v", fields["Tags"])
+	}
+}
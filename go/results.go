@@ -0,0 +1,328 @@
+package structuredparse
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// AsSlice normalizes a result value to a slice: a value produced by a repeated
+// label is returned as-is, a []string list (a SplitOn or ParagraphsAsArray
+// value) is converted item by item, a single value is wrapped in a
+// one-element slice, and nil (an absent label) yields nil.
+func AsSlice(v interface{}) []interface{} {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		return val
+	case []string:
+		items := make([]interface{}, len(val))
+		for i, s := range val {
+			items[i] = s
+		}
+		return items
+	default:
+		return []interface{}{val}
+	}
+}
+
+// AsStringSlice normalizes a result value to a string slice like AsSlice.
+// Elements that are not strings, such as parsed JSON values, are formatted
+// with fmt.Sprint.
+func AsStringSlice(v interface{}) []string {
+	if strs, ok := v.([]string); ok {
+		return strs
+	}
+	items := AsSlice(v)
+	if items == nil {
+		return nil
+	}
+	strs := make([]string, len(items))
+	for i, item := range items {
+		if s, ok := item.(string); ok {
+			strs[i] = s
+		} else {
+			strs[i] = fmt.Sprint(item)
+		}
+	}
+	return strs
+}
+
+// GetPath returns the value at an RFC 6901 JSON pointer (e.g. "/Config/threshold")
+// within a parse result, descending into parsed JSON objects and arrays. The
+// first segment names a result field using its original label casing. The empty
+// pointer refers to the whole result. It reports false if the pointer is
+// malformed or does not resolve.
+func GetPath(result map[string]interface{}, pointer string) (interface{}, bool) {
+	if pointer == "" {
+		return result, true
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, false
+	}
+	return lookupPath(result, pointerSegments(pointer))
+}
+
+// pointerSegments splits a non-empty JSON pointer into unescaped segments.
+func pointerSegments(pointer string) []string {
+	segments := strings.Split(pointer[1:], "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(strings.ReplaceAll(segment, "~1", "/"), "~0", "~")
+	}
+	return segments
+}
+
+// Redact returns a copy of result with the values of the named fields
+// replaced by mask, for logging output that may contain secrets. Each entry
+// in fields is either a field name in its original label casing or a JSON
+// pointer as accepted by GetPath (e.g. "/Action Input/api_key") reaching into
+// a parsed value. A pointer segment that is not an index applies to every
+// element of an array, so a key is redacted in each value of a repeated
+// label. Fields that do not resolve are ignored. The input is not modified;
+// values that are not redacted are shared with it.
+func Redact(result map[string]interface{}, fields []string, mask string) map[string]interface{} {
+	redacted := make(map[string]interface{}, len(result))
+	for k, v := range result {
+		redacted[k] = v
+	}
+	for _, field := range fields {
+		path := []string{field}
+		if strings.HasPrefix(field, "/") {
+			path = pointerSegments(field)
+		}
+		redacted = redactPath(redacted, path, mask).(map[string]interface{})
+	}
+	return redacted
+}
+
+// redactPath returns value with the element at path replaced by mask,
+// copying each map and slice it changes.
+func redactPath(value interface{}, path []string, mask string) interface{} {
+	if len(path) == 0 {
+		return mask
+	}
+	switch node := value.(type) {
+	case map[string]interface{}:
+		next, ok := node[path[0]]
+		if !ok {
+			return value
+		}
+		copied := make(map[string]interface{}, len(node))
+		for k, v := range node {
+			copied[k] = v
+		}
+		copied[path[0]] = redactPath(next, path[1:], mask)
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(node))
+		copy(copied, node)
+		if idx, err := strconv.Atoi(path[0]); err == nil {
+			if idx >= 0 && idx < len(node) {
+				copied[idx] = redactPath(node[idx], path[1:], mask)
+			}
+			return copied
+		}
+		for i, item := range node {
+			copied[i] = redactPath(item, path, mask)
+		}
+		return copied
+	case []string:
+		idx, err := strconv.Atoi(path[0])
+		if err != nil || idx < 0 || idx >= len(node) || len(path) > 1 {
+			return value
+		}
+		copied := make([]string, len(node))
+		copy(copied, node)
+		copied[idx] = mask
+		return copied
+	default:
+		return value
+	}
+}
+
+// ResultsEqual reports whether two parse outputs are equal. It recursively
+// compares maps and slices, treating a []map[string]interface{} (as returned by
+// ParseBlocks) the same as an equivalent []interface{} (as produced by decoding
+// JSON), and falls back to reflect.DeepEqual for other values.
+func ResultsEqual(a, b interface{}) bool {
+	a, b = normalizeResult(a), normalizeResult(b)
+	switch aVal := a.(type) {
+	case map[string]interface{}:
+		bVal, ok := b.(map[string]interface{})
+		if !ok || len(aVal) != len(bVal) {
+			return false
+		}
+		for k, v := range aVal {
+			bv, exists := bVal[k]
+			if !exists || !ResultsEqual(v, bv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bVal, ok := b.([]interface{})
+		if !ok || len(aVal) != len(bVal) {
+			return false
+		}
+		for i := range aVal {
+			if !ResultsEqual(aVal[i], bVal[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return reflect.DeepEqual(a, b)
+	}
+}
+
+// ResultsEqualIgnoring reports whether two parse results are equal, as by
+// ResultsEqual, once the named top-level fields are removed from both. It
+// suits snapshot tests whose results include nondeterministic fields such as
+// a timestamp.
+func ResultsEqualIgnoring(a, b map[string]interface{}, ignore []string) bool {
+	return ResultsEqual(withoutFields(a, ignore), withoutFields(b, ignore))
+}
+
+// withoutFields returns a shallow copy of result without the named fields.
+func withoutFields(result map[string]interface{}, fields []string) map[string]interface{} {
+	trimmed := make(map[string]interface{}, len(result))
+	for k, v := range result {
+		trimmed[k] = v
+	}
+	for _, field := range fields {
+		delete(trimmed, field)
+	}
+	return trimmed
+}
+
+// normalizeResult converts a slice of block maps to a generic slice so it can
+// be compared with decoded JSON.
+func normalizeResult(v interface{}) interface{} {
+	blocks, ok := v.([]map[string]interface{})
+	if !ok {
+		return v
+	}
+	generic := make([]interface{}, len(blocks))
+	for i := range blocks {
+		generic[i] = blocks[i]
+	}
+	return generic
+}
+
+// ToValues flattens a parse result into url.Values for form-encoded systems.
+// Strings are used as-is; slices become repeated keys; any other value (a
+// parsed JSON object, a number or a flag) is encoded as a JSON string.
+func ToValues(result map[string]interface{}) url.Values {
+	values := make(url.Values, len(result))
+	for field, value := range result {
+		switch v := value.(type) {
+		case []interface{}:
+			values[field] = []string{}
+			for _, item := range v {
+				values.Add(field, valueString(item))
+			}
+		case []string:
+			values[field] = append([]string{}, v...)
+		default:
+			values.Set(field, valueString(v))
+		}
+	}
+	return values
+}
+
+// FromValues rebuilds a parse result from url.Values produced by ToValues,
+// using labels to restore each field's shape: repeated labels become slices,
+// JSON and nested labels are decoded, flags become booleans and list labels
+// (SplitOn or ParagraphsAsArray) become []string. Keys are matched to label
+// names exactly; keys with no label are ignored and labels with no key get
+// their empty value.
+func FromValues(v url.Values, labels []Label) map[string]interface{} {
+	result := make(map[string]interface{}, len(labels))
+	for _, label := range labels {
+		raw := v[label.Name]
+		isList := label.SplitOn != "" || label.ParagraphsAsArray
+		switch {
+		case label.FlagOnly:
+			present, _ := strconv.ParseBool(v.Get(label.Name))
+			result[label.Name] = present
+		case label.repeats():
+			items := make([]interface{}, len(raw))
+			for i, item := range raw {
+				items[i] = decodeValue(label, item, isList)
+			}
+			result[label.Name] = items
+		case isList:
+			result[label.Name] = append([]string{}, raw...)
+		case len(raw) == 0:
+			result[label.Name] = ""
+		default:
+			result[label.Name] = decodeValue(label, raw[0], false)
+		}
+	}
+	return result
+}
+
+// valueString renders a single result value as a form value.
+func valueString(value interface{}) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
+
+// decodeValue restores a single form value for label. JSON that fails to
+// decode is kept as the original string.
+func decodeValue(label Label, raw string, isList bool) interface{} {
+	if !isList && !label.IsJSON && len(label.NestedLabels) == 0 {
+		return raw
+	}
+	if isList {
+		var items []string
+		if json.Unmarshal([]byte(raw), &items) != nil {
+			return raw
+		}
+		return items
+	}
+	var decoded interface{}
+	if json.Unmarshal([]byte(raw), &decoded) != nil {
+		return raw
+	}
+	return decoded
+}
+
+// ParseTyped parses text with p and decodes the result into a T, typically a
+// struct whose fields map to labels through encoding/json: by json tag
+// (`json:"Action Input"`) or, untagged, by field name compared
+// case-insensitively. Labels that were absent or empty are skipped, leaving
+// their fields at the zero value. Text values stay strings, so a numeric
+// field needs the ",string" tag option.
+//
+// The errors are those of Parse. If the result cannot be decoded into a T,
+// that is reported as a further error and the zero value of T is returned.
+func ParseTyped[T any](p *Parser, text string) (T, []string) {
+	var typed T
+	result, errs := p.Parse(text)
+	present := make(map[string]interface{}, len(result))
+	for k, v := range result {
+		if v != "" {
+			present[k] = v
+		}
+	}
+	data, err := json.Marshal(present)
+	if err == nil {
+		err = json.Unmarshal(data, &typed)
+	}
+	if err != nil {
+		var zero T
+		return zero, append(errs, "cannot decode result into "+reflect.TypeOf(&typed).Elem().String()+": "+err.Error())
+	}
+	return typed, errs
+}
@@ -1,42 +1,310 @@
 package structuredparse
 
 import (
+	"strconv"
 	"strings"
 )
 
+// ValidationReport sorts the errors of a parse by kind, as returned by
+// ParseValidated, so rules engines can react without matching messages.
+type ValidationReport struct {
+	MissingRequired    []string            `json:"missingRequired,omitempty"`    // Original names of missing Required labels
+	FailedDependencies []DependencyFailure `json:"failedDependencies,omitempty"` // Unmet RequiredWith dependencies
+	JSONErrors         []JSONFailure       `json:"jsonErrors,omitempty"`         // Invalid, too deep or unexpected JSON values
+	Other              []*ParseError       `json:"-"`                            // Every other error and warning
+}
+
+// DependencyFailure is a RequiredWith dependency that was not met.
+type DependencyFailure struct {
+	Label      string `json:"label"`      // Original name of the label with the dependency
+	Dependency string `json:"dependency"` // The dependency as declared in RequiredWith
+	Message    string `json:"message"`
+}
+
+// JSONFailure is a JSON label whose value could not be used.
+type JSONFailure struct {
+	Label   string    `json:"label"` // Original name of the label
+	Code    ErrorCode `json:"code"`  // CodeJSON, CodeJSONDepth or CodeUnknownJSONKey
+	Message string    `json:"message"`
+}
+
+// Valid reports whether the report holds no errors; warnings do not count.
+func (r ValidationReport) Valid() bool {
+	if len(r.MissingRequired) > 0 || len(r.FailedDependencies) > 0 || len(r.JSONErrors) > 0 {
+		return false
+	}
+	for _, e := range r.Other {
+		if !e.Warning {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseValidated parses the text like ParseE and sorts the errors into a
+// ValidationReport. MaxErrors applies before sorting.
+func (p *Parser) ParseValidated(text string) (map[string]interface{}, ValidationReport) {
+	results, errs := p.ParseE(text)
+	var report ValidationReport
+	for _, e := range errs {
+		switch e.Code {
+		case CodeRequired:
+			report.MissingRequired = append(report.MissingRequired, e.Label)
+		case CodeRequiredWith:
+			report.FailedDependencies = append(report.FailedDependencies, DependencyFailure{Label: e.Label, Dependency: e.Dependency, Message: e.Message})
+		case CodeJSON, CodeJSONDepth, CodeUnknownJSONKey:
+			report.JSONErrors = append(report.JSONErrors, JSONFailure{Label: e.Label, Code: e.Code, Message: e.Message})
+		default:
+			report.Other = append(report.Other, e)
+		}
+	}
+	return results, report
+}
+
 // validateDependencies checks required and required_with constraints.
-func (p *Parser) validateDependencies(data map[string][]string) []string {
-	errList := []string{}
+// A label's checks are skipped entirely when any label in its UnlessPresent is present.
+// RequiredWith entries may use a dotted path ("Action Input.id") to require a
+// key inside the parsed value of a JSON label. Under EnforceOrder, order (the
+// labels by first appearance) is checked against declaration order.
+func (p *Parser) validateDependencies(data map[string][]string, parsed map[string][]interface{}, present map[string]bool, order []string) []*ParseError {
+	errList := []*ParseError{}
 	for _, label := range p.labels {
 		key := label.Name
-		entries, present := data[key]
-		missing := !present || len(entries) == 0 || (len(entries) == 1 && entries[0] == "")
-		
+		missing := p.isMissing(key, data, present)
+
 		originalName := p.originalNames[key]
 		if originalName == "" {
 			originalName = key
 		}
 
+		if p.anyPresent(label.UnlessPresent, data, present) {
+			continue
+		}
+
 		if label.Required && missing {
-			errList = append(errList, "'"+originalName+"' is required")
+			errList = append(errList, &ParseError{
+				Code:    CodeRequired,
+				Label:   originalName,
+				Message: "'" + originalName + "' is required",
+				Detail:  missingDetail(originalName, present[key]),
+			})
+		} else if label.Recommended && missing {
+			errList = append(errList, &ParseError{
+				Code:    CodeRecommended,
+				Label:   originalName,
+				Message: "warning: '" + originalName + "' is recommended but missing",
+				Detail:  missingDetail(originalName, present[key]),
+				Warning: true,
+			})
+		}
+		if count := len(data[key]); label.MinCount > 0 && count < label.MinCount {
+			errList = append(errList, &ParseError{
+				Code:    CodeCount,
+				Label:   originalName,
+				Message: "'" + originalName + "' requires at least " + occurrences(label.MinCount) + ", got " + strconv.Itoa(count),
+			})
+		} else if label.MaxCount > 0 && count > label.MaxCount {
+			errList = append(errList, &ParseError{
+				Code:    CodeCount,
+				Label:   originalName,
+				Message: "'" + originalName + "' allows at most " + occurrences(label.MaxCount) + ", got " + strconv.Itoa(count),
+			})
 		}
 		if len(label.RequiredWith) > 0 {
 			for _, dep := range label.RequiredWith {
-				depKey := strings.ToLower(dep)
-				depEntries, depPresent := data[depKey]
-				depMissing := !depPresent || len(depEntries) == 0 || (len(depEntries) == 1 && depEntries[0] == "")
-				if !missing {
-					if depMissing {
-						depOriginalName := p.originalNames[depKey]
-						if depOriginalName == "" {
-							depOriginalName = dep
-						}
-						errList = append(errList, "'"+originalName+"' requires '"+depOriginalName+"'")
+				if missing {
+					continue
+				}
+				depKey, path := p.splitDependencyPath(dep)
+				depMissing := p.isMissing(depKey, data, present)
+				depOriginalName := p.originalNames[depKey]
+				if depOriginalName == "" {
+					depOriginalName = dep
+				}
+				if depMissing {
+					errList = append(errList, &ParseError{
+						Code:       CodeRequiredWith,
+						Label:      originalName,
+						Dependency: dep,
+						Message:    "'" + originalName + "' requires '" + depOriginalName + "'",
+						Detail:     missingDetail(depOriginalName, present[depKey]),
+					})
+					continue
+				}
+				if len(path) == 0 {
+					continue
+				}
+				for _, value := range parsed[depKey] {
+					if _, ok := lookupPath(value, path); !ok {
+						errList = append(errList, &ParseError{
+							Code:       CodeRequiredWith,
+							Label:      originalName,
+							Dependency: dep,
+							Message:    "'" + originalName + "' requires '" + depOriginalName + "' to contain key '" + strings.Join(path, ".") + "'",
+							Detail:     "'" + depOriginalName + "' is present but its value has no key '" + strings.Join(path, ".") + "'",
+						})
+						break
 					}
 				}
 			}
 		}
 	}
+	if p.opts.AgentMode {
+		errList = append(errList, p.agentModeErrors(data, present)...)
+	}
+	if p.opts.EnforceOrder {
+		errList = append(errList, p.orderErrors(order)...)
+	}
+	return errList
+}
+
+// orderErrors reports each label in order that first appeared after a label
+// declared later than it.
+func (p *Parser) orderErrors(order []string) []*ParseError {
+	var errList []*ParseError
+	latest := ""
+	for _, name := range order {
+		if latest != "" && indexOfLabel(p.labels, name) < indexOfLabel(p.labels, latest) {
+			originalName := p.originalNames[name]
+			errList = append(errList, &ParseError{
+				Code:    CodeOrder,
+				Label:   originalName,
+				Message: "'" + originalName + "' appears after '" + p.originalNames[latest] + "' but is declared before it",
+			})
+			continue
+		}
+		latest = name
+	}
 	return errList
 }
 
+// agentModeErrors checks the ReAct constraints enabled by AgentMode. The
+// Action Input requirement is skipped when Action already declares it in
+// RequiredWith, which reports it instead.
+func (p *Parser) agentModeErrors(data map[string][]string, present map[string]bool) []*ParseError {
+	action, hasAction := p.labelMap["action"]
+	if !hasAction || p.isMissing("action", data, present) {
+		return nil
+	}
+	var errList []*ParseError
+	actionName := p.originalNames["action"]
+	if _, ok := p.labelMap["action input"]; ok && p.isMissing("action input", data, present) {
+		declared := false
+		for _, dep := range action.RequiredWith {
+			declared = declared || strings.EqualFold(dep, "action input")
+		}
+		if !declared {
+			inputName := p.originalNames["action input"]
+			errList = append(errList, &ParseError{
+				Code:       CodeRequiredWith,
+				Label:      actionName,
+				Dependency: inputName,
+				Message:    "'" + actionName + "' requires '" + inputName + "'",
+				Detail:     missingDetail(inputName, present["action input"]),
+			})
+		}
+	}
+	if _, ok := p.labelMap["final answer"]; ok && !p.isMissing("final answer", data, present) {
+		answerName := p.originalNames["final answer"]
+		errList = append(errList, &ParseError{
+			Code:    CodeConflict,
+			Label:   actionName,
+			Message: "'" + actionName + "' and '" + answerName + "' cannot both appear",
+		})
+	}
+	return errList
+}
+
+// occurrences formats a count of occurrences, as in "1 occurrence".
+func occurrences(n int) string {
+	if n == 1 {
+		return "1 occurrence"
+	}
+	return strconv.Itoa(n) + " occurrences"
+}
+
+// missingDetail describes why a label counts as missing: it either never
+// appeared or appeared with a blank value.
+func missingDetail(name string, appeared bool) string {
+	if appeared {
+		return "'" + name + "' appeared but its value is empty"
+	}
+	return "'" + name + "' did not appear in the text"
+}
+
+// anyPresent reports whether any of the named labels is present (not missing).
+func (p *Parser) anyPresent(names []string, data map[string][]string, present map[string]bool) bool {
+	for _, name := range names {
+		if !p.isMissing(strings.ToLower(name), data, present) {
+			return true
+		}
+	}
+	return false
+}
+
+// isMissing reports whether a label should be treated as absent for validation:
+// it never appeared or collected no non-empty value. Under EmptyJSONAsNull a JSON
+// label that appeared without a value counts as present (its value is null), and
+// FlagOnly and AllowEmpty labels are missing only if they never appeared.
+func (p *Parser) isMissing(key string, data map[string][]string, present map[string]bool) bool {
+	if labelDef := p.labelMap[key]; labelDef.FlagOnly || labelDef.AllowEmpty {
+		return !present[key]
+	}
+	entries, ok := data[key]
+	missing := !ok || len(entries) == 0 || (len(entries) == 1 && entries[0] == "")
+	if missing && present[key] && p.opts.EmptyJSONAsNull && p.labelMap[key].IsJSON {
+		return false
+	}
+	return missing
+}
+
+// splitDependencyPath splits a RequiredWith entry into a lowercase label name and
+// an optional key path into that label's JSON value. The longest dotted prefix
+// naming a known label wins, so label names that themselves contain dots still
+// resolve; entries without a matching prefix are treated as plain label names.
+func (p *Parser) splitDependencyPath(dep string) (string, []string) {
+	lowerDep := strings.ToLower(dep)
+	if _, ok := p.labelMap[lowerDep]; ok || !strings.Contains(dep, ".") {
+		return lowerDep, nil
+	}
+	parts := strings.Split(dep, ".")
+	for i := len(parts) - 1; i > 0; i-- {
+		prefix := strings.ToLower(strings.Join(parts[:i], "."))
+		if labelDef, ok := p.labelMap[prefix]; ok && labelDef.IsJSON {
+			return prefix, parts[i:]
+		}
+	}
+	return lowerDep, nil
+}
+
+// lookupPath descends into a parsed JSON value following the given keys.
+// Object keys are matched exactly; array elements, and items of a []string
+// list such as a SplitOn value, are addressed by index.
+func lookupPath(value interface{}, path []string) (interface{}, bool) {
+	current := value
+	for _, segment := range path {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			next, ok := node[segment]
+			if !ok {
+				return nil, false
+			}
+			current = next
+		case []interface{}:
+			idx, err := strconv.Atoi(segment)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			current = node[idx]
+		case []string:
+			idx, err := strconv.Atoi(segment)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			current = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
@@ -23,7 +23,7 @@ func wasmParse(this js.Value, args []js.Value) interface{} {
 	labels := convertLabelsFromJSON(req.Labels)
 	opts := convertOptionsFromJSON(req.Options)
 
-	parser, err := NewParser(labels, opts)
+	parser, err := wasmParserCache.get(labels, opts)
 	if err != nil {
 		return createErrorResponse("failed to create parser: " + err.Error())
 	}
@@ -36,7 +36,7 @@ func wasmParse(this js.Value, args []js.Value) interface{} {
 		Errors: errors,
 	}
 
-	responseJSON, err := json.Marshal(response)
+	responseJSON, err := marshalResponse(response, req.Pretty)
 	if err != nil {
 		return createErrorResponse("failed to marshal response: " + err.Error())
 	}
@@ -60,7 +60,7 @@ func wasmParseBlocks(this js.Value, args []js.Value) interface{} {
 	labels := convertLabelsFromJSON(req.Labels)
 	opts := convertOptionsFromJSON(req.Options)
 
-	parser, err := NewParser(labels, opts)
+	parser, err := wasmParserCache.get(labels, opts)
 	if err != nil {
 		return createErrorResponse("failed to create parser: " + err.Error())
 	}
@@ -73,7 +73,7 @@ func wasmParseBlocks(this js.Value, args []js.Value) interface{} {
 		Errors: errors,
 	}
 
-	responseJSON, err := json.Marshal(response)
+	responseJSON, err := marshalResponse(response, req.Pretty)
 	if err != nil {
 		return createErrorResponse("failed to marshal response: " + err.Error())
 	}
@@ -81,6 +81,47 @@ func wasmParseBlocks(this js.Value, args []js.Value) interface{} {
 	return string(responseJSON)
 }
 
+// wasmDiscoverLabels suggests a label configuration from sample output.
+// It accepts the sample text and returns a JSON string with WasmResponse whose
+// result is the suggested []LabelJSON.
+func wasmDiscoverLabels(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeString {
+		return createErrorResponse("expected 1 argument: sample text string")
+	}
+
+	labels := convertLabelsToJSON(DiscoverLabels(args[0].String()))
+	if labels == nil {
+		labels = []LabelJSON{}
+	}
+
+	responseJSON, err := json.Marshal(WasmResponse{Ok: true, Result: labels})
+	if err != nil {
+		return createErrorResponse("failed to marshal response: " + err.Error())
+	}
+
+	return string(responseJSON)
+}
+
+// wasmParserCache holds compiled parsers reused across requests with identical
+// labels and options.
+var wasmParserCache = newParserCache(defaultParserCacheSize)
+
+// wasmClearParserCache removes every cached parser.
+func wasmClearParserCache(this js.Value, args []js.Value) interface{} {
+	wasmParserCache.clear()
+	return nil
+}
+
+// wasmSetParserCacheSize sets the maximum number of cached parsers.
+// It accepts a single number; zero disables caching.
+func wasmSetParserCacheSize(this js.Value, args []js.Value) interface{} {
+	if len(args) != 1 || args[0].Type() != js.TypeNumber {
+		return createErrorResponse("expected 1 argument: cache size number")
+	}
+	wasmParserCache.setMaxSize(args[0].Int())
+	return nil
+}
+
 // wasmVersion returns the version of the WASM module.
 func wasmVersion(this js.Value, args []js.Value) interface{} {
 	return "1.0.0"
@@ -91,4 +132,7 @@ func RegisterWasmFunctions() {
 	js.Global().Set("wasmParse", js.FuncOf(wasmParse))
 	js.Global().Set("wasmParseBlocks", js.FuncOf(wasmParseBlocks))
 	js.Global().Set("wasmVersion", js.FuncOf(wasmVersion))
+	js.Global().Set("wasmClearParserCache", js.FuncOf(wasmClearParserCache))
+	js.Global().Set("wasmSetParserCacheSize", js.FuncOf(wasmSetParserCacheSize))
+	js.Global().Set("wasmDiscoverLabels", js.FuncOf(wasmDiscoverLabels))
 }
@@ -5,24 +5,76 @@ import "encoding/json"
 // WasmResponse represents the standard response structure for all WASM functions.
 // It contains either a result or an error, along with any parsing errors.
 type WasmResponse struct {
-	Ok     bool                   `json:"ok"`
-	Result interface{}            `json:"result,omitempty"`
-	Errors []string               `json:"errors,omitempty"`
-	Error  string                 `json:"error,omitempty"` // For system errors
+	Ok     bool        `json:"ok"`
+	Result interface{} `json:"result,omitempty"`
+	Errors []string    `json:"errors,omitempty"`
+	Error  string      `json:"error,omitempty"` // For system errors
 }
 
 // LabelJSON represents a label in JSON format for WASM consumption.
 type LabelJSON struct {
-	Name         string   `json:"name"`
-	Required     bool     `json:"required,omitempty"`
-	RequiredWith []string `json:"requiredWith,omitempty"`
-	IsJSON       bool     `json:"isJson,omitempty"`
-	IsBlockStart bool     `json:"isBlockStart,omitempty"`
+	Name                string            `json:"name"`
+	Required            bool              `json:"required,omitempty"`
+	RequiredWith        []string          `json:"requiredWith,omitempty"`
+	IsJSON              bool              `json:"isJson,omitempty"`
+	IsBlockStart        bool              `json:"isBlockStart,omitempty"`
+	Repeatable          bool              `json:"repeatable,omitempty"`
+	NestedLabels        []LabelJSON       `json:"nestedLabels,omitempty"`
+	StopAtBlankLine     bool              `json:"stopAtBlankLine,omitempty"`
+	Dedent              bool              `json:"dedent,omitempty"`
+	UnlessPresent       []string          `json:"unlessPresent,omitempty"`
+	BlockFields         []string          `json:"blockFields,omitempty"`
+	IncludeLabelInValue bool              `json:"includeLabelInValue,omitempty"`
+	ParseUnit           bool              `json:"parseUnit,omitempty"`
+	DedupeValues        bool              `json:"dedupeValues,omitempty"`
+	NormalizeCase       string            `json:"normalizeCase,omitempty"`
+	Enum                []string          `json:"enum,omitempty"`
+	ValueOnNextLine     bool              `json:"valueOnNextLine,omitempty"`
+	FlagOnly            bool              `json:"flagOnly,omitempty"`
+	Computed            string            `json:"computed,omitempty"`
+	ParagraphsAsArray   bool              `json:"paragraphsAsArray,omitempty"`
+	Recommended         bool              `json:"recommended,omitempty"`
+	MinCount            int               `json:"minCount,omitempty"`
+	MaxCount            int               `json:"maxCount,omitempty"`
+	SplitOn             string            `json:"splitOn,omitempty"`
+	Opaque              bool              `json:"opaque,omitempty"`
+	AllowEmpty          bool              `json:"allowEmpty,omitempty"`
+	RequireExactCase    bool              `json:"requireExactCase,omitempty"`
+	Meta                map[string]string `json:"meta,omitempty"`
+	NumberedSuffix      bool              `json:"numberedSuffix,omitempty"`
+	TrimTrailing        string            `json:"trimTrailing,omitempty"`
+	PreserveIndent      bool              `json:"preserveIndent,omitempty"`
 }
 
 // ParserOptionsJSON represents parser options in JSON format.
 type ParserOptionsJSON struct {
-	Separators string `json:"separators,omitempty"`
+	Separators                  string            `json:"separators,omitempty"`
+	RequireSpaceAfterSeparator  bool              `json:"requireSpaceAfterSeparator,omitempty"`
+	ImplicitFirstBlock          bool              `json:"implicitFirstBlock,omitempty"`
+	FuzzyLabelDistance          int               `json:"fuzzyLabelDistance,omitempty"`
+	BlockIntroKey               string            `json:"blockIntroKey,omitempty"`
+	MaxLineLength               int               `json:"maxLineLength,omitempty"`
+	ErrorOnLongLine             bool              `json:"errorOnLongLine,omitempty"`
+	DuplicatePolicy             string            `json:"duplicatePolicy,omitempty"` // "collect" (default), "first", or "last"
+	NormalizeUnicodePunctuation bool              `json:"normalizeUnicodePunctuation,omitempty"`
+	EmptyJSONAsNull             bool              `json:"emptyJsonAsNull,omitempty"`
+	WarnAmbiguousMatches        bool              `json:"warnAmbiguousMatches,omitempty"`
+	TreatTabAsSeparator         bool              `json:"treatTabAsSeparator,omitempty"`
+	BlockSeparatorPattern       string            `json:"blockSeparatorPattern,omitempty"`
+	DisallowInterleavedFields   bool              `json:"disallowInterleavedFields,omitempty"`
+	MaxErrors                   int               `json:"maxErrors,omitempty"`
+	StripLabelPrefixRunes       string            `json:"stripLabelPrefixRunes,omitempty"`
+	GlobalAliases               map[string]string `json:"globalAliases,omitempty"`
+	BlockStartAsKey             bool              `json:"blockStartAsKey,omitempty"`
+	AgentMode                   bool              `json:"agentMode,omitempty"`
+	FlexibleWordSeparators      bool              `json:"flexibleWordSeparators,omitempty"`
+	SplitInlineLabels           bool              `json:"splitInlineLabels,omitempty"`
+	EnforceOrder                bool              `json:"enforceOrder,omitempty"`
+	SkipPreambleUntilFirstLabel bool              `json:"skipPreambleUntilFirstLabel,omitempty"`
+	MaxJSONDepth                int               `json:"maxJsonDepth,omitempty"`
+	SkipEmptyBlocks             bool              `json:"skipEmptyBlocks,omitempty"`
+	FallbackSingleBlock         bool              `json:"fallbackSingleBlock,omitempty"`
+	UnwrapOuterFenceOnly        bool              `json:"unwrapOuterFenceOnly,omitempty"`
 }
 
 // NewParserRequest represents the request to create a new parser.
@@ -36,6 +88,7 @@ type ParseRequest struct {
 	Labels  []LabelJSON        `json:"labels"`
 	Options *ParserOptionsJSON `json:"options,omitempty"`
 	Text    string             `json:"text"`
+	Pretty  bool               `json:"pretty,omitempty"` // Indent the response JSON for display
 }
 
 // ParseBlocksRequest represents a request to parse text into blocks.
@@ -43,6 +96,7 @@ type ParseBlocksRequest struct {
 	Labels  []LabelJSON        `json:"labels"`
 	Options *ParserOptionsJSON `json:"options,omitempty"`
 	Text    string             `json:"text"`
+	Pretty  bool               `json:"pretty,omitempty"` // Indent the response JSON for display
 }
 
 // convertLabelsFromJSON converts JSON labels to internal Label structs.
@@ -50,26 +104,143 @@ func convertLabelsFromJSON(jsonLabels []LabelJSON) []Label {
 	labels := make([]Label, len(jsonLabels))
 	for i, jl := range jsonLabels {
 		labels[i] = Label{
-			Name:         jl.Name,
-			Required:     jl.Required,
-			RequiredWith: jl.RequiredWith,
-			IsJSON:       jl.IsJSON,
-			IsBlockStart: jl.IsBlockStart,
+			Name:                jl.Name,
+			Required:            jl.Required,
+			RequiredWith:        jl.RequiredWith,
+			IsJSON:              jl.IsJSON,
+			IsBlockStart:        jl.IsBlockStart,
+			Repeatable:          jl.Repeatable,
+			NestedLabels:        convertLabelsFromJSON(jl.NestedLabels),
+			StopAtBlankLine:     jl.StopAtBlankLine,
+			Dedent:              jl.Dedent,
+			UnlessPresent:       jl.UnlessPresent,
+			BlockFields:         jl.BlockFields,
+			IncludeLabelInValue: jl.IncludeLabelInValue,
+			ParseUnit:           jl.ParseUnit,
+			DedupeValues:        jl.DedupeValues,
+			NormalizeCase:       jl.NormalizeCase,
+			Enum:                jl.Enum,
+			ValueOnNextLine:     jl.ValueOnNextLine,
+			FlagOnly:            jl.FlagOnly,
+			Computed:            jl.Computed,
+			ParagraphsAsArray:   jl.ParagraphsAsArray,
+			Recommended:         jl.Recommended,
+			MinCount:            jl.MinCount,
+			MaxCount:            jl.MaxCount,
+			SplitOn:             jl.SplitOn,
+			Opaque:              jl.Opaque,
+			AllowEmpty:          jl.AllowEmpty,
+			RequireExactCase:    jl.RequireExactCase,
+			Meta:                jl.Meta,
+			NumberedSuffix:      jl.NumberedSuffix,
+			TrimTrailing:        jl.TrimTrailing,
+			PreserveIndent:      jl.PreserveIndent,
 		}
 	}
 	return labels
 }
 
+// convertLabelsToJSON converts Labels to their JSON representation.
+func convertLabelsToJSON(labels []Label) []LabelJSON {
+	if labels == nil {
+		return nil
+	}
+	jsonLabels := make([]LabelJSON, len(labels))
+	for i, l := range labels {
+		jsonLabels[i] = LabelJSON{
+			Name:                l.Name,
+			Required:            l.Required,
+			RequiredWith:        l.RequiredWith,
+			IsJSON:              l.IsJSON,
+			IsBlockStart:        l.IsBlockStart,
+			Repeatable:          l.Repeatable,
+			NestedLabels:        convertLabelsToJSON(l.NestedLabels),
+			StopAtBlankLine:     l.StopAtBlankLine,
+			Dedent:              l.Dedent,
+			UnlessPresent:       l.UnlessPresent,
+			BlockFields:         l.BlockFields,
+			IncludeLabelInValue: l.IncludeLabelInValue,
+			ParseUnit:           l.ParseUnit,
+			DedupeValues:        l.DedupeValues,
+			NormalizeCase:       l.NormalizeCase,
+			Enum:                l.Enum,
+			ValueOnNextLine:     l.ValueOnNextLine,
+			FlagOnly:            l.FlagOnly,
+			Computed:            l.Computed,
+			ParagraphsAsArray:   l.ParagraphsAsArray,
+			Recommended:         l.Recommended,
+			MinCount:            l.MinCount,
+			MaxCount:            l.MaxCount,
+			SplitOn:             l.SplitOn,
+			Opaque:              l.Opaque,
+			AllowEmpty:          l.AllowEmpty,
+			RequireExactCase:    l.RequireExactCase,
+			Meta:                l.Meta,
+			NumberedSuffix:      l.NumberedSuffix,
+			TrimTrailing:        l.TrimTrailing,
+			PreserveIndent:      l.PreserveIndent,
+		}
+	}
+	return jsonLabels
+}
+
 // convertOptionsFromJSON converts JSON options to internal ParserOptions.
 func convertOptionsFromJSON(jsonOpts *ParserOptionsJSON) *ParserOptions {
 	if jsonOpts == nil {
 		return nil
 	}
 	return &ParserOptions{
-		Separators: jsonOpts.Separators,
+		Separators:                  jsonOpts.Separators,
+		RequireSpaceAfterSeparator:  jsonOpts.RequireSpaceAfterSeparator,
+		ImplicitFirstBlock:          jsonOpts.ImplicitFirstBlock,
+		FuzzyLabelDistance:          jsonOpts.FuzzyLabelDistance,
+		BlockIntroKey:               jsonOpts.BlockIntroKey,
+		MaxLineLength:               jsonOpts.MaxLineLength,
+		ErrorOnLongLine:             jsonOpts.ErrorOnLongLine,
+		DuplicatePolicy:             convertDuplicatePolicyFromJSON(jsonOpts.DuplicatePolicy),
+		NormalizeUnicodePunctuation: jsonOpts.NormalizeUnicodePunctuation,
+		EmptyJSONAsNull:             jsonOpts.EmptyJSONAsNull,
+		WarnAmbiguousMatches:        jsonOpts.WarnAmbiguousMatches,
+		TreatTabAsSeparator:         jsonOpts.TreatTabAsSeparator,
+		BlockSeparatorPattern:       jsonOpts.BlockSeparatorPattern,
+		DisallowInterleavedFields:   jsonOpts.DisallowInterleavedFields,
+		MaxErrors:                   jsonOpts.MaxErrors,
+		StripLabelPrefixRunes:       jsonOpts.StripLabelPrefixRunes,
+		GlobalAliases:               jsonOpts.GlobalAliases,
+		BlockStartAsKey:             jsonOpts.BlockStartAsKey,
+		AgentMode:                   jsonOpts.AgentMode,
+		FlexibleWordSeparators:      jsonOpts.FlexibleWordSeparators,
+		SplitInlineLabels:           jsonOpts.SplitInlineLabels,
+		EnforceOrder:                jsonOpts.EnforceOrder,
+		SkipPreambleUntilFirstLabel: jsonOpts.SkipPreambleUntilFirstLabel,
+		MaxJSONDepth:                jsonOpts.MaxJSONDepth,
+		SkipEmptyBlocks:             jsonOpts.SkipEmptyBlocks,
+		FallbackSingleBlock:         jsonOpts.FallbackSingleBlock,
+		UnwrapOuterFenceOnly:        jsonOpts.UnwrapOuterFenceOnly,
 	}
 }
 
+// convertDuplicatePolicyFromJSON maps a JSON duplicate policy name to a DuplicatePolicy.
+// Unknown names fall back to the default collect behavior.
+func convertDuplicatePolicyFromJSON(policy string) DuplicatePolicy {
+	switch policy {
+	case "first":
+		return DuplicateKeepFirst
+	case "last":
+		return DuplicateKeepLast
+	default:
+		return DuplicateCollect
+	}
+}
+
+// marshalResponse encodes a response, indented by two spaces when pretty is set.
+func marshalResponse(response WasmResponse, pretty bool) ([]byte, error) {
+	if pretty {
+		return json.MarshalIndent(response, "", "  ")
+	}
+	return json.Marshal(response)
+}
+
 // createErrorResponse creates a JSON error response string.
 func createErrorResponse(errMsg string) string {
 	response := WasmResponse{
@@ -79,4 +250,3 @@ func createErrorResponse(errMsg string) string {
 	responseJSON, _ := json.Marshal(response)
 	return string(responseJSON)
 }
-